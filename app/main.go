@@ -35,6 +35,7 @@ func main() {
 		case syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
 			return
 		case syscall.SIGHUP:
+			server.Reload()
 		default:
 			return
 		}
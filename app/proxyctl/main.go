@@ -0,0 +1,442 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/coschain/contentos-go/prototype"
+	"github.com/coschain/contentos-go/rpc/pb"
+	"os"
+	"proxy/config"
+	"proxy/database"
+	"proxy/define"
+	"proxy/job"
+	"proxy/rpc"
+	"sort"
+	"time"
+)
+
+const usage = `usage: proxyctl [-config file] <command> [flags]
+
+commands:
+  user         -type t (-id id | -name chain_name)   show the redis record and the chain account of a user
+  height       [-set n]                              show or reset the reward block height
+  deadletters  [-start n] [-limit n]                 list dead letters
+  requeue      -request_id id                        dispatch a dead letter again (QueueMode redis only)
+  reconcile    [-repair]                             check redis records against the chain, optionally repair them
+  verifykeys   [-type t]                             check stored user keys against the keys derived from KeySeed
+  namereg                                            register the names of existing accounts in the name registry
+  config       [-file f]                             validate a config file
+`
+
+func main() {
+	configFile := flag.String("config", "", "config file, the same one the proxy uses by default")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+	if *configFile != "" {
+		os.Setenv("PROXY_CONFIG_FILE", *configFile)
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch args[0] {
+	case "user":
+		err = user(args[1:])
+	case "height":
+		err = height(args[1:])
+	case "deadletters":
+		err = deadLetters(args[1:])
+	case "requeue":
+		err = requeue(args[1:])
+	case "reconcile":
+		err = reconcile(args[1:])
+	case "verifykeys":
+		err = verifyKeys(args[1:])
+	case "namereg":
+		err = nameRegistry(args[1:])
+	case "config":
+		err = validate(args[1:], *configFile)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+}
+
+func newDB() *database.DB {
+	db := database.NewDB()
+	if db == nil {
+		panic("init db error")
+	}
+	return db
+}
+
+/**
+ * 查询用户
+ *   按链上名字查找时需要遍历该 app 的全部账号
+ */
+func user(args []string) error {
+	fs := flag.NewFlagSet("user", flag.ExitOnError)
+	t := fs.Int64("type", 0, "app type")
+	id := fs.String("id", "", "user id in the app")
+	name := fs.String("name", "", "account name on chain")
+	fs.Parse(args)
+
+	conf := config.GetConfig()
+	app := conf.AppMap[*t]
+	if app == nil {
+		return fmt.Errorf("unknown app type:%v", *t)
+	}
+	db := newDB()
+	prefix := define.IdPrefix + app.Name
+
+	key := ""
+	switch {
+	case *id != "":
+		key = prefix + *id
+	case *name != "":
+		var err error
+		if key, err = findByName(db, prefix, *name); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("-id or -name is required")
+	}
+
+	record, err := db.HGETALL(key)
+	if err != nil {
+		return err
+	}
+	if len(record) == 0 {
+		return fmt.Errorf("user:%v not found", key)
+	}
+	if _, ok := record[define.PrivateKey]; ok {
+		record[define.PrivateKey] = "******"
+	}
+	fields := make([]string, 0, len(record))
+	for f := range record {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	fmt.Println("redis", key)
+	for _, f := range fields {
+		fmt.Printf("  %v: %v\n", f, record[f])
+	}
+
+	pool := rpc.NewRpcPool(conf.RpcAddr, conf.RpcTimeOut)
+	resp, err := pool.GetClient().GetAccountByName(&grpcpb.GetAccountByNameRequest{
+		AccountName: &prototype.AccountName{Value: record[define.Name]},
+	})
+	if err != nil {
+		return err
+	}
+	info := resp.GetInfo()
+	if info.GetAccountName() == nil {
+		fmt.Println("chain: account not found")
+		return nil
+	}
+	fmt.Println("chain", info.GetAccountName().GetValue())
+	fmt.Printf("  public_key: %v\n", info.GetPublicKey().ToWIF())
+	fmt.Printf("  coin: %v\n", info.GetCoin().GetValue())
+	fmt.Printf("  vest: %v\n", info.GetVest().GetValue())
+	fmt.Printf("  created: %v\n", time.Unix(int64(info.GetCreatedTime().GetUtcSeconds()), 0))
+	fmt.Printf("  posts: %v followers: %v following: %v trx: %v\n", info.GetPostCount(), info.GetFollowerCount(), info.GetFollowingCount(), info.GetTrxCount())
+	return nil
+}
+
+func findByName(db *database.DB, prefix, name string) (string, error) {
+	cursor := 0
+	for {
+		next, keys, err := db.SCAN(cursor, prefix+"*", 1000)
+		if err != nil {
+			return "", err
+		}
+		for _, key := range keys {
+			if n, err := db.HGETString(key, define.Name); err == nil && n == name {
+				return key, nil
+			}
+		}
+		if next == 0 {
+			return "", fmt.Errorf("name:%v not found", name)
+		}
+		cursor = next
+	}
+}
+
+/**
+ * 查看或重置奖励扫描的区块高度
+ */
+func height(args []string) error {
+	fs := flag.NewFlagSet("height", flag.ExitOnError)
+	set := fs.Int64("set", -1, "block height to continue scanning after")
+	fs.Parse(args)
+
+	db := newDB()
+	h, err := db.GETUint64(define.BlockHeight)
+	if err != nil {
+		return err
+	}
+	fmt.Println("reward height:", h)
+	if *set < 0 {
+		return nil
+	}
+	if err := db.SET(define.BlockHeight, uint64(*set)); err != nil {
+		return err
+	}
+	fmt.Println("reward height set to:", *set)
+	return nil
+}
+
+func deadLetters(args []string) error {
+	fs := flag.NewFlagSet("deadletters", flag.ExitOnError)
+	start := fs.Int("start", 0, "offset of the first dead letter")
+	limit := fs.Int("limit", 20, "number of dead letters")
+	fs.Parse(args)
+	if *start < 0 || *limit <= 0 {
+		return fmt.Errorf("invalid -start or -limit")
+	}
+
+	list, err := job.DeadLetters(newDB(), *start, *start+*limit-1)
+	if err != nil {
+		return err
+	}
+	for _, d := range list {
+		fmt.Printf("%v request:%v type:%v instance:%v error:%v\n  msg:%s\n", time.Unix(d.Time, 0).Format("2006-01-02 15:04:05"), d.RequestId, d.Type, d.Instance, d.Error, d.Msg)
+	}
+	fmt.Printf("%v dead letter(s)\n", len(list))
+	return nil
+}
+
+/**
+ * 重新投递死信
+ *   本地队列模式下消息只存在于进程内，需要使用 admin 接口
+ */
+func requeue(args []string) error {
+	fs := flag.NewFlagSet("requeue", flag.ExitOnError)
+	requestId := fs.String("request_id", "", "request id of the dead letter")
+	fs.Parse(args)
+	if *requestId == "" {
+		return fmt.Errorf("-request_id is required")
+	}
+
+	conf := config.GetConfig()
+	if conf.QueueMode != define.QueueRedis {
+		return fmt.Errorf("queue mode is %v, requeue through /admin/deadletters/requeue instead", conf.QueueMode)
+	}
+	db := newDB()
+	found, err := job.RequeueDeadLetter(db, job.NewRedisDispatcher(db, conf.PartitionCount), *requestId)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("dead letter:%v not found", *requestId)
+	}
+	fmt.Println("requeued", *requestId)
+	return nil
+}
+
+/**
+ * 对账
+ *   -repair 把修复消息放入 redis 队列，由运行中的 proxy 处理，因此需要 redis 队列模式
+ */
+func reconcile(args []string) error {
+	fs := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "queue missing accounts and posts to be written to chain")
+	fs.Parse(args)
+
+	conf := config.GetConfig()
+	db := newDB()
+	pool := rpc.NewRpcPool(conf.RpcAddr, conf.RpcTimeOut)
+	var dispatcher job.Dispatcher
+	if conf.QueueMode == define.QueueRedis {
+		dispatcher = job.NewRedisDispatcher(db, conf.PartitionCount)
+	} else if *repair {
+		return fmt.Errorf("repair needs the redis queue mode, use the admin api of the proxy instead")
+	}
+	report, err := job.NewReconciler(job.NewJob(db, os.Stderr, 0, pool), nil, dispatcher).Sweep(*repair)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("checked accounts:%v posts:%v errors:%v\n", report.Accounts, report.Posts, report.Errors)
+	for _, key := range report.Missing {
+		fmt.Println("missing", key)
+	}
+	for _, key := range report.Repaired {
+		fmt.Println("repaired", key)
+	}
+	for _, issue := range report.Skipped {
+		fmt.Println("skipped", issue.Key, issue.Reason)
+	}
+	for _, issue := range report.Failed {
+		fmt.Println("failed", issue.Key, issue.Reason)
+	}
+	for _, issue := range report.Irreparable {
+		fmt.Println("irreparable", issue.Key, issue.Reason)
+	}
+	return nil
+}
+
+/**
+ * 校验用户私钥
+ *   用 KeySeed 重新派生每个账号的私钥并与 redis 中保存的比较；
+ *   标记为派生的账号不一致时报错，未标记的账号（随机密钥或标记之前创建的）只统计
+ */
+func verifyKeys(args []string) error {
+	fs := flag.NewFlagSet("verifykeys", flag.ExitOnError)
+	t := fs.Int64("type", 0, "app type, 0 for every app")
+	fs.Parse(args)
+
+	conf := config.GetConfig()
+	if conf.KeySeed == "" {
+		return fmt.Errorf("KeySeed not configured")
+	}
+	apps := make([]*config.App, 0, len(conf.AppMap))
+	for _, app := range conf.AppMap {
+		if *t == 0 || app.Type == *t {
+			apps = append(apps, app)
+		}
+	}
+	if len(apps) == 0 {
+		return fmt.Errorf("unknown app type:%v", *t)
+	}
+	sort.Slice(apps, func(i, k int) bool { return apps[i].Type < apps[k].Type })
+
+	db := newDB()
+	matched, unmarked, random, mismatched, failed := 0, 0, 0, 0, 0
+	for _, app := range apps {
+		prefix := define.IdPrefix + app.Name
+		cursor := 0
+		for {
+			next, keys, err := db.SCAN(cursor, prefix+"*", 1000)
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				account, err := db.HGETALL(key)
+				stored := account[define.PrivateKey]
+				if err != nil || stored == "" {
+					fmt.Println("failed", key, "no private key", err)
+					failed++
+					continue
+				}
+				_, derived, err := job.DerivedKey(key, app)
+				if err != nil {
+					fmt.Println("failed", key, err)
+					failed++
+					continue
+				}
+				marked := account[define.KeyDerived] == "1"
+				switch {
+				case derived == stored && marked:
+					matched++
+				case derived == stored:
+					fmt.Println("unmarked", key)
+					unmarked++
+				case marked:
+					fmt.Println("mismatch", key)
+					mismatched++
+				default:
+					random++
+				}
+			}
+			if next == 0 {
+				break
+			}
+			cursor = next
+		}
+	}
+	fmt.Printf("matched:%v unmarked:%v random:%v mismatched:%v failed:%v\n", matched, unmarked, random, mismatched, failed)
+	if mismatched > 0 || failed > 0 {
+		return fmt.Errorf("%v key(s) do not match", mismatched+failed)
+	}
+	return nil
+}
+
+/**
+ * 补登记账号名
+ *   登记表启用前创建的账号不在表中，新账号可能生成同名而在链上创建失败；
+ *   遍历全部账号把名字登记到各自的 id 上，已被其他 id 登记的名字报告为冲突
+ */
+func nameRegistry(args []string) error {
+	fs := flag.NewFlagSet("namereg", flag.ExitOnError)
+	fs.Parse(args)
+
+	conf := config.GetConfig()
+	apps := make([]*config.App, 0, len(conf.AppMap))
+	for _, app := range conf.AppMap {
+		apps = append(apps, app)
+	}
+	sort.Slice(apps, func(i, k int) bool { return apps[i].Type < apps[k].Type })
+
+	db := newDB()
+	registered, kept, conflicts := 0, 0, 0
+	for _, app := range apps {
+		prefix := define.IdPrefix + app.Name
+		cursor := 0
+		for {
+			next, keys, err := db.SCAN(cursor, prefix+"*", 1000)
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				name, err := db.HGETString(key, define.Name)
+				if err != nil {
+					return err
+				}
+				if name == "" {
+					continue
+				}
+				claimed, err := db.SETNX(define.NameRegistryPrefix+name, key, 0)
+				if err != nil {
+					return err
+				}
+				if claimed {
+					registered++
+					continue
+				}
+				owner, err := db.GETId(define.NameRegistryPrefix + name)
+				if err != nil {
+					return err
+				}
+				if owner != key {
+					fmt.Println("conflict", name, "registered to", owner, "used by", key)
+					conflicts++
+					continue
+				}
+				kept++
+			}
+			if next == 0 {
+				break
+			}
+			cursor = next
+		}
+	}
+	fmt.Printf("registered:%v already:%v conflicts:%v\n", registered, kept, conflicts)
+	if conflicts > 0 {
+		return fmt.Errorf("%v name(s) registered to another account", conflicts)
+	}
+	return nil
+}
+
+func validate(args []string, configFile string) error {
+	fs := flag.NewFlagSet("config", flag.ExitOnError)
+	file := fs.String("file", configFile, "config file to check")
+	fs.Parse(args)
+	if *file == "" {
+		*file = "config.yml"
+	}
+
+	if err := config.Validate(*file); err != nil {
+		return err
+	}
+	fmt.Println(*file, "ok")
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"proxy/config"
+	"proxy/database"
+	"proxy/define"
+	"proxy/job"
+	"proxy/rpc"
+	"time"
+)
+
+func main() {
+	from := flag.Uint64("from", 1, "first block height to rescan")
+	to := flag.Uint64("to", 0, "last block height to rescan, 0 for the credited height")
+	apply := flag.Bool("apply", false, "write the corrections to redis")
+	pause := flag.Bool("pause", false, "pause reward crediting during the scan, needed to compare with vest from block 1")
+	flag.Parse()
+
+	conf := config.GetConfig()
+
+	db := database.NewDB()
+	if db == nil {
+		panic("init db error")
+	}
+	pool := rpc.NewRpcPool(conf.RpcAddr, conf.RpcTimeOut)
+
+	r := job.NewRewardRecompute(db, pool)
+	owner := ""
+	if *pause {
+		owner = fmt.Sprintf("rewardfix:%v", os.Getpid())
+		if err := r.Pause(owner, 24*time.Hour); err != nil {
+			fmt.Println("pause error:", err)
+			os.Exit(1)
+		}
+	}
+
+	err := run(r, db, *from, *to, *apply)
+	if owner != "" {
+		if rerr := r.Resume(owner); rerr != nil {
+			fmt.Println("resume error:", rerr)
+		}
+	}
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(r *job.RewardRecompute, db *database.DB, from, to uint64, apply bool) error {
+	if to == 0 {
+		height, err := db.GETUint64(define.BlockHeight)
+		if err != nil {
+			return err
+		}
+		to = height
+	}
+	scan, err := r.Scan(from, to)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range scan.Diffs {
+		fmt.Printf("id:%v name:%v expected:%v recorded:%v delta:%v\n", d.Id, d.Name, d.Expected, d.Recorded, d.Delta)
+	}
+	fmt.Printf("%v account(s) differ in block %v-%v, compared with %v\n", len(scan.Diffs), from, to, scan.Basis)
+	if !scan.Applicable {
+		fmt.Println("not applicable:", scan.Reason)
+	}
+
+	if !apply || len(scan.Diffs) == 0 {
+		return nil
+	}
+	if err := r.Apply(scan); err != nil {
+		return err
+	}
+	fmt.Println("corrections applied")
+	return nil
+}
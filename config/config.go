@@ -2,16 +2,47 @@ package config
 
 import (
 	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/jinzhu/configor"
+	"io/ioutil"
 	"os"
 	"proxy/define"
+	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
-type Creator struct {
-	CreatorName   string
-	CreatorPriKey string
+// App is one registered app, its Name is also the prefix of its redis keys.
+type App struct {
+	Type                  int64
+	Name                  string
+	CreatorName           string
+	CreatorPriKey         string
+	Endpoints             map[string]bool // empty means every endpoint
+	ContractDeployerName  string
+	ContractName          string
+	ContractCommentMethod string
+	ContractSignInMethod  string
+	ContractLikeMethod    string
+	TransferName          string
+	TransferPriKey        string
+	TransferAmount        uint64 // top-up for contract callers
+	TransferLimit         uint64 // no top-up once the caller holds this much
+	NamePolicy            string // how account names are made from nicknames
+	ContractCaller        string // user signs calls itself, transfer signs them for the user
+}
+
+// Enabled reports whether the app may call the given api endpoint.
+func (a *App) Enabled(endpoint string) bool {
+	return len(a.Endpoints) == 0 || a.Endpoints[endpoint]
+}
+
+type AppKey struct {
+	Type      int64
+	ApiSecret string
 }
 
 type Config struct {
@@ -24,14 +55,56 @@ type Config struct {
 	RedisMaxActive   int      `default:"100"`
 	RedisIdleTimeout int      `default:"30"`
 	JobCount         int      `default:"100"`
+	JobMinCount      int      `default:"0"`
+	JobMaxRpcLatency int      `default:"1000"`
 	TokenPerSecond   int      `default:"1000"`
 	TokenMax         int      `default:"1500"`
-	Creators         []struct {
-		Type          string `default:""`
-		CreatorName   string `default:""`
-		CreatorPriKey string `default:""`
+	// per key token buckets, 0 disables the limit
+	RateLimitMode      string `default:"local"`
+	AppTokenPerSecond  int    `default:"0"`
+	AppTokenMax        int    `default:"0"`
+	IpTokenPerSecond   int    `default:"0"`
+	IpTokenMax         int    `default:"0"`
+	UserTokenPerSecond int    `default:"0"`
+	UserTokenMax       int    `default:"0"`
+	Apps               []struct {
+		Type              int64  `default:"0"`
+		Name              string `default:""`
+		CreatorName       string `default:""`
+		CreatorPriKey     string `default:""`
+		CreatorPriKeyFile string `default:""`
+		Endpoints         []string
+		// contract and faucet, empty values fall back to the global settings
+		ContractDeployerName  string `default:""`
+		ContractName          string `default:""`
+		ContractCommentMethod string `default:""`
+		ContractSignInMethod  string `default:""`
+		ContractLikeMethod    string `default:""`
+		TransferName          string `default:""`
+		TransferPriKey        string `default:""`
+		TransferPriKeyFile    string `default:""`
+		TransferAmount        uint64 `default:"0"`
+		TransferLimit         uint64 `default:"0"`
+		NamePolicy            string `default:""`
+		ContractCaller        string `default:""`
 	}
-	CreatorMap            map[string]*Creator
+	// deprecated, only read when Apps is empty
+	Creators []struct {
+		Type              string `default:""`
+		CreatorName       string `default:""`
+		CreatorPriKey     string `default:""`
+		CreatorPriKeyFile string `default:""`
+	}
+	AppMap       map[int64]*App
+	AppNameMap   map[string]*App
+	AuthRequired bool `default:"false"`
+	AuthWindow   int  `default:"300"`
+	AppKeys      []struct {
+		Type      int64  `default:"0"`
+		ApiKey    string `default:""`
+		ApiSecret string `default:""`
+	}
+	AppKeyMap             map[string]*AppKey
 	ContractDeployerName  string `default:""`
 	RpcTimeOut            int    `default:"350"`
 	ContractName          string `default:""`
@@ -41,50 +114,285 @@ type Config struct {
 	RewardMinInterval     int    `default:"100"`
 	TransferName          string `default:""`
 	TransferPriKey        string `default:""`
+	TransferPriKeyFile    string `default:""`
+	TransferAmount        uint64 `default:"300000"`
+	TransferLimit         uint64 `default:"300000"`
+	NamePolicy            string `default:"translit"`
+	ContractCaller        string `default:"user"`
+	InstanceId            string `default:""`
+	LeaderLeaseTime       int    `default:"10"`
+	QueueMode             string `default:"local"`
+	PartitionCount        int    `default:"64"`
+	MessageDeadline       int    `default:"0"`
+	MessageDeadlines      map[string]int
+	MessagePriorities     map[string]string
+	LaneStarveLimit       int    `default:"10"`
+	AdminListenAddr       string `default:""`
+	AdminToken            string `default:""`
+	// seconds an account looked up for /api/account/info is cached
+	AccountCacheTime int `default:"5"`
+	// redis to chain reconciliation, 0 interval disables the background sweep
+	ReconcileInterval        int `default:"0"`
+	ReconcileCheckPerSecond  int `default:"20"`
+	ReconcileRepairPerSecond int `default:"1"`
+	// seconds a new or repaired record is left alone, its message may still be queued
+	ReconcileGrace int `default:"600"`
+	// hex master seed, user keys are derived from it instead of random when set
+	KeySeed     string `default:""`
+	KeySeedFile string `default:""`
 }
 
-var once sync.Once
-var c *Config
+// envPrefix prefixes the environment variables that override the config file
+const envPrefix = "PROXY"
+
+var (
+	once    sync.Once
+	current atomic.Value
+	mutex   sync.Mutex
+)
 
 func GetConfig() *Config {
 	once.Do(func() {
+		c, err := load(configFileName())
+		if err != nil {
+			panic(err.Error())
+		}
+		current.Store(c)
+	})
+	return current.Load().(*Config)
+}
+
+// Validate loads a config file and reports the first problem in it.
+func Validate(configName string) error {
+	_, err := load(configName)
+	return err
+}
+
+/**
+ * 加载配置
+ *   配置文件可以通过环境变量 PROXY_CONFIG_FILE 指定，否则按 idc 选择；
+ *   每个配置项都可以被环境变量 PROXY_<字段名大写> 覆盖，列表中的项为 PROXY_APPS_0_CREATORPRIKEY 这种形式；
+ *   私钥可以放在单独的文件中（*PriKeyFile），文件不能对其他用户开放权限。
+ */
+func configFileName() string {
 
-		// default and online config name
-		configName := "config.yml"
+	// default and online config name
+	configName := "config.yml"
 
-		// get idc
-		fb, err := os.Open("/data/app/idc/go-idc.ini")
-		defer fb.Close()
+	// get idc
+	fb, err := os.Open("/data/app/idc/go-idc.ini")
+	defer fb.Close()
+	if err == nil {
+		rd := bufio.NewReader(fb)
+		idc, err := rd.ReadString('\n')
 		if err == nil {
-			rd := bufio.NewReader(fb)
-			idc, err := rd.ReadString('\n')
-			if err == nil {
-				configName = "config." + strings.Replace(idc, "\n", "", -1) + ".yml"
-			}
+			configName = "config." + strings.Replace(idc, "\n", "", -1) + ".yml"
 		}
+	}
+	if name := os.Getenv(envPrefix + "_CONFIG_FILE"); name != "" {
+		configName = name
+	}
+	return configName
+}
 
-		// get config
-		c = &Config{}
-		configor.Load(c, configName)
-		c.CreatorMap = make(map[string]*Creator)
-		if b, info := checkParam(c); !b {
-			panic(info)
+func load(configName string) (*Config, error) {
+
+	// get config
+	c := &Config{}
+	if err := configor.New(&configor.Config{ENVPrefix: envPrefix}).Load(c, configName); err != nil {
+		return nil, err
+	}
+	if err := loadKeyFiles(c); err != nil {
+		return nil, err
+	}
+	if b, info := checkParam(c); !b {
+		return nil, errors.New(info)
+	}
+	if err := buildApps(c); err != nil {
+		return nil, err
+	}
+	if c.InstanceId == "" {
+		host, _ := os.Hostname()
+		c.InstanceId = fmt.Sprintf("%v:%v", host, os.Getpid())
+	}
+	c.AppKeyMap = make(map[string]*AppKey)
+	for _, item := range c.AppKeys {
+		if !checkEmpty(item.ApiKey) || !checkEmpty(item.ApiSecret) {
+			return nil, fmt.Errorf("config app key or secret empty")
 		}
-		for _, item := range c.Creators {
-			if !checkType(item.Type) {
-				panic("config creator type invalid")
-			}
-			if !checkEmpty(item.CreatorName) {
-				panic("config creator name empty")
+		if _, ok := c.AppKeyMap[item.ApiKey]; ok {
+			return nil, fmt.Errorf("config app key duplicate")
+		}
+		if c.AppMap[item.Type] == nil {
+			return nil, fmt.Errorf("config app key type invalid: %v", item.Type)
+		}
+		c.AppKeyMap[item.ApiKey] = &AppKey{Type: item.Type, ApiSecret: item.ApiSecret}
+	}
+	return c, nil
+}
+
+// loadKeyFiles fills the private keys that are kept in separate files.
+func loadKeyFiles(c *Config) error {
+	if err := setKeyFromFile(&c.TransferPriKey, c.TransferPriKeyFile); err != nil {
+		return err
+	}
+	if err := setKeyFromFile(&c.KeySeed, c.KeySeedFile); err != nil {
+		return err
+	}
+	for i := range c.Creators {
+		if err := setKeyFromFile(&c.Creators[i].CreatorPriKey, c.Creators[i].CreatorPriKeyFile); err != nil {
+			return err
+		}
+	}
+	for i := range c.Apps {
+		if err := setKeyFromFile(&c.Apps[i].CreatorPriKey, c.Apps[i].CreatorPriKeyFile); err != nil {
+			return err
+		}
+		if err := setKeyFromFile(&c.Apps[i].TransferPriKey, c.Apps[i].TransferPriKeyFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setKeyFromFile(key *string, path string) error {
+	if path == "" {
+		return nil
+	}
+	if *key != "" {
+		return fmt.Errorf("config key file %v set together with an inline key", path)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if perm := info.Mode().Perm(); perm&0007 != 0 {
+		return fmt.Errorf("config key file %v is accessible by others, mode %v", path, perm)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	*key = strings.TrimSpace(string(data))
+	if *key == "" {
+		return fmt.Errorf("config key file %v empty", path)
+	}
+	return nil
+}
+
+// reloadable settings are read on use, so a reload takes effect at once
+var reloadable = map[string]bool{
+	"RpcAddr":               true,
+	"TokenPerSecond":        true,
+	"TokenMax":              true,
+	"RateLimitMode":         true,
+	"AppTokenPerSecond":     true,
+	"AppTokenMax":           true,
+	"IpTokenPerSecond":      true,
+	"IpTokenMax":            true,
+	"UserTokenPerSecond":    true,
+	"UserTokenMax":          true,
+	"Apps":                  true,
+	"Creators":              true,
+	"AppMap":                true,
+	"AppNameMap":            true,
+	"AuthRequired":          true,
+	"AuthWindow":            true,
+	"AppKeys":               true,
+	"AppKeyMap":             true,
+	"ContractDeployerName":  true,
+	"ContractName":          true,
+	"ContractCommentMethod": true,
+	"ContractSignInMethod":  true,
+	"ContractLikeMethod":    true,
+	"RewardMinInterval":     true,
+	"TransferName":          true,
+	"TransferPriKey":        true,
+	"TransferPriKeyFile":    true,
+	"TransferAmount":        true,
+	"TransferLimit":         true,
+	"NamePolicy":            true,
+	"ContractCaller":        true,
+	"MessageDeadline":       true,
+	"MessageDeadlines":      true,
+	"AdminToken":            true,
+	"AccountCacheTime":      true,
+}
+
+// derived settings are built from others and not reported
+var derived = map[string]bool{"AppMap": true, "AppNameMap": true, "AppKeyMap": true}
+
+// Reload reads the config file again. A config that fails validation is
+// rejected as a whole. Otherwise the reloadable settings are swapped in at
+// once, the rest keep their running values and are returned in restart.
+// Apps only reload when none is added, removed, renamed or retyped.
+// apply runs before the swap, an error from it keeps the running config.
+func Reload(apply func(c *Config, changed []string) error) (changed []string, restart []string, err error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	old := GetConfig()
+	c, err := load(configFileName())
+	if err != nil {
+		return nil, nil, err
+	}
+	sameApps := sameAppIdentity(old, c)
+	ov, nv := reflect.ValueOf(old).Elem(), reflect.ValueOf(c).Elem()
+	for i := 0; i < nv.NumField(); i++ {
+		name := nv.Type().Field(i).Name
+		if reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		if !reloadable[name] || appFields[name] && !sameApps {
+			if !derived[name] {
+				restart = append(restart, name)
 			}
-			if !checkEmpty(item.CreatorPriKey) {
-				panic("config creator private key empty")
+			nv.Field(i).Set(ov.Field(i))
+			continue
+		}
+		if !derived[name] {
+			changed = append(changed, name)
+		}
+	}
+	// the kept apps must still cover every app key
+	for _, key := range c.AppKeyMap {
+		if c.AppMap[key.Type] == nil {
+			c.AppKeys, c.AppKeyMap = old.AppKeys, old.AppKeyMap
+			restart = append(restart, "AppKeys")
+			for i, name := range changed {
+				if name == "AppKeys" {
+					changed = append(changed[:i], changed[i+1:]...)
+					break
+				}
 			}
-			ct := &Creator{CreatorName: item.CreatorName, CreatorPriKey: item.CreatorPriKey}
-			c.CreatorMap[item.Type] = ct
+			break
 		}
-	})
-	return c
+	}
+	if apply != nil {
+		if err := apply(c, changed); err != nil {
+			return nil, nil, err
+		}
+	}
+	current.Store(c)
+	return changed, restart, nil
+}
+
+// appFields only reload when every app keeps its type and name, the name is
+// part of the redis keys and the type is stored with requests.
+var appFields = map[string]bool{"Apps": true, "Creators": true, "AppMap": true, "AppNameMap": true}
+
+// sameAppIdentity reports whether both configs have the same apps by type
+// and name, settings inside an app may differ.
+func sameAppIdentity(old, c *Config) bool {
+	if len(old.AppMap) != len(c.AppMap) {
+		return false
+	}
+	for t, app := range old.AppMap {
+		if n := c.AppMap[t]; n == nil || n.Name != app.Name {
+			return false
+		}
+	}
+	return true
 }
 
 func checkParam(c *Config) (bool, string) {
@@ -97,37 +405,226 @@ func checkParam(c *Config) (bool, string) {
 	if "" == c.ApiLogPath || "" == c.JobLogPath {
 		return false, "config log path empty"
 	}
-	if 0 == len(c.Creators) {
-		return false, "config creators empty"
+	if 0 == len(c.Apps) && 0 == len(c.Creators) {
+		return false, "config apps empty"
+	}
+	if c.JobCount <= 0 || c.JobMinCount < 0 || c.JobMinCount > c.JobCount {
+		return false, "config job count invalid"
 	}
-	if "" == c.ContractDeployerName {
-		return false, "config contract deployer empty"
+	if c.RateLimitMode != define.QueueLocal && c.RateLimitMode != define.QueueRedis {
+		return false, "config rate limit mode invalid"
 	}
-	if "" == c.ContractName {
-		return false, "config contract contract empty"
+	if c.AppTokenMax < 0 || c.IpTokenMax < 0 || c.UserTokenMax < 0 {
+		return false, "config token max invalid"
 	}
-	if "" == c.ContractCommentMethod {
-		return false, "config contract contract comment method empty"
+	if c.LaneStarveLimit <= 0 {
+		return false, "config lane starve limit invalid"
 	}
-	if "" == c.ContractLikeMethod {
-		return false, "config contract contract like method empty"
+	if c.AuthRequired && 0 == len(c.AppKeys) {
+		return false, "config app keys empty"
 	}
-	if "" == c.ContractSignInMethod {
-		return false, "config contract contract sign method empty"
+	if c.AuthWindow <= 0 {
+		return false, "config auth window invalid"
 	}
-	if "" == c.TransferName {
-		return false, "config transfer name empty"
+	if "" != c.AdminListenAddr && "" == c.AdminToken {
+		return false, "config admin token empty"
 	}
-	if "" == c.TransferPriKey {
-		return false, "config transfer private key empty"
+	if c.LeaderLeaseTime <= 0 {
+		return false, "config leader lease time invalid"
+	}
+	if c.QueueMode != define.QueueLocal && c.QueueMode != define.QueueRedis {
+		return false, "config queue mode invalid"
+	}
+	if c.PartitionCount <= 0 {
+		return false, "config partition count invalid"
+	}
+	if c.AccountCacheTime < 0 {
+		return false, "config account cache time invalid"
+	}
+	if seed, err := hex.DecodeString(c.KeySeed); err != nil || c.KeySeed != "" && (len(seed) < 16 || len(seed) > 64) {
+		return false, "config key seed invalid, want 16 to 64 bytes in hex"
+	}
+	if c.ReconcileInterval < 0 || c.ReconcileCheckPerSecond <= 0 || c.ReconcileRepairPerSecond <= 0 || c.ReconcileGrace < 0 {
+		return false, "config reconcile invalid"
 	}
 	return true, ""
 }
 
-func checkType(t string) bool {
-	if t != define.PGStr && t != define.ContentosStr && t != define.Game2048Str {
+// legacyTypes are the app types used before apps were configurable.
+var legacyTypes = map[string]int64{"PG": 1, "CT": 2, "G2": 3}
+
+// buildApps fills AppMap and AppNameMap from Apps, or from the deprecated
+// Creators list when no app is configured.
+func buildApps(c *Config) error {
+	c.AppMap = make(map[int64]*App)
+	c.AppNameMap = make(map[string]*App)
+	if len(c.Apps) == 0 {
+		for _, item := range c.Creators {
+			t, ok := legacyTypes[item.Type]
+			if !ok {
+				return fmt.Errorf("config creator type invalid: %v", item.Type)
+			}
+			app := &App{Type: t, Name: item.Type, CreatorName: item.CreatorName, CreatorPriKey: item.CreatorPriKey}
+			inheritGlobal(c, app)
+			if err := addApp(c, app); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, item := range c.Apps {
+		app := &App{
+			Type:                  item.Type,
+			Name:                  item.Name,
+			CreatorName:           item.CreatorName,
+			CreatorPriKey:         item.CreatorPriKey,
+			ContractDeployerName:  item.ContractDeployerName,
+			ContractName:          item.ContractName,
+			ContractCommentMethod: item.ContractCommentMethod,
+			ContractSignInMethod:  item.ContractSignInMethod,
+			ContractLikeMethod:    item.ContractLikeMethod,
+			TransferName:          item.TransferName,
+			TransferPriKey:        item.TransferPriKey,
+			TransferAmount:        item.TransferAmount,
+			TransferLimit:         item.TransferLimit,
+			NamePolicy:            item.NamePolicy,
+			ContractCaller:        item.ContractCaller,
+		}
+		inheritGlobal(c, app)
+		if len(item.Endpoints) > 0 {
+			app.Endpoints = make(map[string]bool)
+			for _, e := range item.Endpoints {
+				app.Endpoints[e] = true
+			}
+		}
+		if err := addApp(c, app); err != nil {
+			return err
+		}
+	}
+	return checkKeyPrefixes(c)
+}
+
+// keyFamilies are the api key prefixes an app name is appended to.
+var keyFamilies = []string{define.IdPrefix, define.PostPrefix, define.LikePrefix, define.CommentPrefix,
+	define.FollowPrefix, define.DatePrefix, define.NamePrefix, define.GamePrefix}
+
+/**
+ * 检查 redis key 前缀不冲突
+ *   key 为 前缀 + app 名 + id，没有分隔符，任意两个 前缀+app 名 不能互为前缀，
+ *   否则不同 app 或不同类型的 key 会重叠。旧版的 app 名之间不检查，它们的 key 已经存在
+ */
+func checkKeyPrefixes(c *Config) error {
+	for _, a := range c.AppMap {
+		for _, b := range c.AppMap {
+			if isLegacy(a) && isLegacy(b) {
+				continue
+			}
+			for _, fa := range keyFamilies {
+				for _, fb := range keyFamilies {
+					if a == b && fa == fb {
+						continue
+					}
+					if strings.HasPrefix(fb+b.Name, fa+a.Name) {
+						return fmt.Errorf("config app name %v overlaps %v in redis keys", a.Name, b.Name)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func isLegacy(app *App) bool {
+	t, ok := legacyTypes[app.Name]
+	return ok && t == app.Type
+}
+
+// inheritGlobal fills the contract and faucet settings an app leaves empty.
+func inheritGlobal(c *Config, app *App) {
+	if app.ContractDeployerName == "" {
+		app.ContractDeployerName = c.ContractDeployerName
+	}
+	if app.ContractName == "" {
+		app.ContractName = c.ContractName
+	}
+	if app.ContractCommentMethod == "" {
+		app.ContractCommentMethod = c.ContractCommentMethod
+	}
+	if app.ContractSignInMethod == "" {
+		app.ContractSignInMethod = c.ContractSignInMethod
+	}
+	if app.ContractLikeMethod == "" {
+		app.ContractLikeMethod = c.ContractLikeMethod
+	}
+	if app.TransferName == "" {
+		app.TransferName = c.TransferName
+		app.TransferPriKey = c.TransferPriKey
+	}
+	if app.TransferAmount == 0 {
+		app.TransferAmount = c.TransferAmount
+	}
+	if app.TransferLimit == 0 {
+		app.TransferLimit = c.TransferLimit
+	}
+	if app.NamePolicy == "" {
+		app.NamePolicy = c.NamePolicy
+	}
+	if app.ContractCaller == "" {
+		app.ContractCaller = c.ContractCaller
+	}
+}
+
+func addApp(c *Config, app *App) error {
+	if app.Type <= 0 {
+		return fmt.Errorf("config app type invalid: %v", app.Type)
+	}
+	if !checkAppName(app.Name) && !isLegacy(app) {
+		return fmt.Errorf("config app name invalid: %v", app.Name)
+	}
+	if !checkEmpty(app.CreatorName) {
+		return fmt.Errorf("config creator name empty: %v", app.Name)
+	}
+	if !checkEmpty(app.CreatorPriKey) {
+		return fmt.Errorf("config creator private key empty: %v", app.Name)
+	}
+	if !checkEmpty(app.ContractDeployerName) || !checkEmpty(app.ContractName) {
+		return fmt.Errorf("config contract empty: %v", app.Name)
+	}
+	if !checkEmpty(app.ContractCommentMethod) || !checkEmpty(app.ContractLikeMethod) || !checkEmpty(app.ContractSignInMethod) {
+		return fmt.Errorf("config contract method empty: %v", app.Name)
+	}
+	if !checkEmpty(app.TransferName) || !checkEmpty(app.TransferPriKey) {
+		return fmt.Errorf("config transfer account empty: %v", app.Name)
+	}
+	if app.NamePolicy != define.NameTranslit && app.NamePolicy != define.NameStrip && app.NamePolicy != define.NameRandom {
+		return fmt.Errorf("config name policy invalid: %v", app.Name)
+	}
+	if app.ContractCaller != define.CallerUser && app.ContractCaller != define.CallerTransfer {
+		return fmt.Errorf("config contract caller invalid: %v", app.Name)
+	}
+	if _, ok := c.AppMap[app.Type]; ok {
+		return fmt.Errorf("config app type duplicate: %v", app.Type)
+	}
+	if _, ok := c.AppNameMap[app.Name]; ok {
+		return fmt.Errorf("config app name duplicate: %v", app.Name)
+	}
+	c.AppMap[app.Type] = app
+	c.AppNameMap[app.Name] = app
+	return nil
+}
+
+// checkAppName only allows ascii letters, the name is followed by numeric ids
+// in redis keys. The legacy G2 is the one name with a digit.
+func checkAppName(name string) bool {
+	if name == "" {
 		return false
 	}
+	for i := 0; i < len(name); i++ {
+		ch := name[i]
+		if !(ch >= 'a' && ch <= 'z') && !(ch >= 'A' && ch <= 'Z') {
+			return false
+		}
+	}
 	return true
 }
 
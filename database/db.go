@@ -1,6 +1,7 @@
 package database
 
 import (
+	"errors"
 	"proxy/config"
 	"github.com/garyburd/redigo/redis"
 	"time"
@@ -204,4 +205,390 @@ func (db *DB) AddReward(key,fieldReward,reward interface{}) (err error) {
 
 	_, err = conn.Do("HINCRBY", key,fieldReward,reward)
 	return
-}
\ No newline at end of file
+}
+func (db *DB) LPUSH(key string, arg interface{}) (err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("LPUSH", key, arg)
+	return
+}
+
+var renewScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+var releaseScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// AcquireLease sets key to owner only if nobody holds it.
+func (db *DB) AcquireLease(key, owner string, ttl time.Duration) (b bool, err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	_, err = redis.String(conn.Do("SET", key, owner, "NX", "PX", int64(ttl/time.Millisecond)))
+	if err != nil {
+		if err == redis.ErrNil {
+			err = nil
+		}
+		return
+	}
+	b = true
+	return
+}
+
+// RenewLease extends the lease only if it is still held by owner.
+func (db *DB) RenewLease(key, owner string, ttl time.Duration) (b bool, err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	n, err := redis.Int(renewScript.Do(conn, key, owner, int64(ttl/time.Millisecond)))
+	b = n == 1
+	return
+}
+
+// ReleaseLease drops the lease only if it is still held by owner.
+func (db *DB) ReleaseLease(key, owner string) (err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	_, err = releaseScript.Do(conn, key, owner)
+	return
+}
+
+// BRPOPLPUSH returns nil data if nothing arrived before timeout.
+func (db *DB) BRPOPLPUSH(source, destination string, timeout int) (data []byte, err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	data, err = redis.Bytes(conn.Do("BRPOPLPUSH", source, destination, timeout))
+	if err != nil {
+		if err == redis.ErrNil {
+			err = nil
+		}
+	}
+	return
+}
+
+func (db *DB) LREM(key string, count int, arg interface{}) (removeNum int, err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	removeNum, err = redis.Int(conn.Do("LREM", key, count, arg))
+	return
+}
+
+func (db *DB) LRANGE(key string, start, stop int) (list [][]byte, err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	list, err = redis.ByteSlices(conn.Do("LRANGE", key, start, stop))
+	return
+}
+
+func (db *DB) ZADD(key string, score int64, member interface{}) (err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("ZADD", key, score, member)
+	return
+}
+
+func (db *DB) ZREMRANGEBYSCORE(key string, min, max interface{}) (err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("ZREMRANGEBYSCORE", key, min, max)
+	return
+}
+
+func (db *DB) ZCARD(key string) (n int, err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	n, err = redis.Int(conn.Do("ZCARD", key))
+	return
+}
+
+// SetStatus writes the fields of a status record and renews its expiry in one transaction.
+func (db *DB) SetStatus(key string, expire int, fields ...interface{}) (err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	if err = conn.Send("MULTI"); err != nil {
+		return
+	}
+	conn.Send("HMSET", append([]interface{}{key}, fields...)...)
+	conn.Send("EXPIRE", key, expire)
+	_, err = conn.Do("EXEC")
+	return
+}
+
+// PushStatus queues data and writes its status record in one transaction, no
+// status is written when fields is empty.
+func (db *DB) PushStatus(queue string, data []byte, key string, expire int, fields ...interface{}) (err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	if err = conn.Send("MULTI"); err != nil {
+		return
+	}
+	conn.Send("LPUSH", queue, data)
+	if len(fields) > 0 {
+		conn.Send("HMSET", append([]interface{}{key}, fields...)...)
+		conn.Send("EXPIRE", key, expire)
+	}
+	_, err = conn.Do("EXEC")
+	return
+}
+
+func (db *DB) ZRANGEBYSCORE(key string, min, max interface{}, count int) (members []string, err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	members, err = redis.Strings(conn.Do("ZRANGEBYSCORE", key, min, max, "LIMIT", 0, count))
+	return
+}
+
+func (db *DB) ZREM(key string, member interface{}) (removeNum int, err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	removeNum, err = redis.Int(conn.Do("ZREM", key, member))
+	return
+}
+
+func (db *DB) HSET(key string, field, value interface{}) (err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("HSET", key, field, value)
+	return
+}
+
+func (db *DB) HGETBytes(key string, field interface{}) (data []byte, err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	data, err = redis.Bytes(conn.Do("HGET", key, field))
+	if err != nil {
+		if err == redis.ErrNil {
+			err = nil
+		}
+	}
+	return
+}
+
+// the bucket is refilled by the redis clock, TIME needs effect replication
+// before redis 5
+var tokenScript = redis.NewScript(1, `
+redis.replicate_commands()
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local b = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(b[1]) or burst
+local last = tonumber(b[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - last) / 1000 * rate)
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "last", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, math.floor(tokens), retry}`)
+
+// TakeToken takes one token from the bucket at key, refilled at rate per
+// second up to burst. retryMs is how long to wait when not allowed.
+func (db *DB) TakeToken(key string, rate float64, burst int) (allowed bool, remaining int, retryMs int64, err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	res, err := redis.Int64s(tokenScript.Do(conn, key, rate, burst))
+	if err != nil || len(res) != 3 {
+		return
+	}
+	allowed = res[0] == 1
+	remaining = int(res[1])
+	retryMs = res[2]
+	return
+}
+
+// SETNX sets key only if it does not exist, expire <= 0 keeps it forever.
+func (db *DB) SETNX(key string, arg interface{}, expire int) (b bool, err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	args := []interface{}{key, arg, "NX"}
+	if expire > 0 {
+		args = append(args, "EX", expire)
+	}
+	_, err = redis.String(conn.Do("SET", args...))
+	if err != nil {
+		if err == redis.ErrNil {
+			err = nil
+		}
+		return
+	}
+	b = true
+	return
+}
+
+func (db *DB) HGETALL(key string) (m map[string]string, err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	m, err = redis.StringMap(conn.Do("HGETALL", key))
+	return
+}
+
+func (db *DB) SCAN(cursor int, match string, count int) (next int, keys []string, err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", match, "COUNT", count))
+	if err != nil {
+		return
+	}
+	if _, err = redis.Scan(values, &next, &keys); err != nil {
+		return
+	}
+	return
+}
+
+func (db *DB) HMSET(key string, args ...interface{}) (err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("HMSET", append([]interface{}{key}, args...)...)
+	return
+}
+
+func (db *DB) GETBytes(key string) (data []byte, err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	data, err = redis.Bytes(conn.Do("GET", key))
+	if err == redis.ErrNil {
+		err = nil
+	}
+	return
+}
+
+func (db *DB) INCR(key string) (n uint64, err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	n, err = redis.Uint64(conn.Do("INCR", key))
+	return
+}
+
+// a block is credited as a whole: vest of every rewarded account, the block's
+// ledger and the height move together, nothing is written while paused or
+// after the caller lost the lease
+var rewardScript = redis.NewScript(5, `
+if redis.call("GET", KEYS[5]) ~= ARGV[3] then
+	return -1
+end
+if redis.call("EXISTS", KEYS[4]) == 1 then
+	return 0
+end
+redis.call("DEL", KEYS[2])
+for i = 4, #ARGV, 2 do
+	redis.call("HINCRBY", ARGV[i], ARGV[2], ARGV[i+1])
+	redis.call("HINCRBY", KEYS[2], ARGV[i], ARGV[i+1])
+end
+redis.call("SETNX", KEYS[3], ARGV[1])
+redis.call("SET", KEYS[1], ARGV[1])
+return 1`)
+
+// ErrLeaseLost is returned by writes fenced with a lease the caller no longer holds.
+var ErrLeaseLost = errors.New("lease lost")
+
+// CreditBlock adds the rewards of one block if owner still holds leaseKey,
+// ok is false while rewards are paused.
+func (db *DB) CreditBlock(heightKey, ledgerKey, startKey, pauseKey, leaseKey, owner string, height uint64, fieldReward string, rewards map[string]uint64) (ok bool, err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	args := []interface{}{heightKey, ledgerKey, startKey, pauseKey, leaseKey, height, fieldReward, owner}
+	for id, reward := range rewards {
+		args = append(args, id, reward)
+	}
+	n, err := redis.Int(rewardScript.Do(conn, args...))
+	if err == nil && n == -1 {
+		err = ErrLeaseLost
+	}
+	ok = n == 1
+	return
+}
+
+// CorrectReward adds delta to the reward of id, rewrites its ledger entries
+// and records the audit entry in one transaction. A zero ledger value removes the entry.
+func (db *DB) CorrectReward(id, fieldReward string, delta int64, ledger map[string]uint64, auditKey string, audit []byte) (err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	if err = conn.Send("MULTI"); err != nil {
+		return
+	}
+	for key, reward := range ledger {
+		if reward == 0 {
+			conn.Send("HDEL", key, id)
+		} else {
+			conn.Send("HSET", key, id, reward)
+		}
+	}
+	if delta != 0 {
+		conn.Send("HINCRBY", id, fieldReward, delta)
+	}
+	conn.Send("LPUSH", auditKey, audit)
+	_, err = conn.Do("EXEC")
+	return
+}
+
+func (db *DB) SETEX(key string, arg interface{}, expire int) (err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	_, err = conn.Do("SET", key, arg, "EX", expire)
+	return
+}
+
+// the scheduled message leaves the schedule and, when queueKey is given,
+// enters the dispatch queue with its status in one step
+var releaseScheduledScript = redis.NewScript(4, `
+if redis.call("ZREM", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call("HDEL", KEYS[2], ARGV[1])
+if ARGV[2] ~= "" then
+	redis.call("LPUSH", KEYS[3], ARGV[2])
+end
+if #ARGV > 3 then
+	redis.call("HMSET", KEYS[4], unpack(ARGV, 4))
+	redis.call("EXPIRE", KEYS[4], ARGV[3])
+end
+return 1`)
+
+// ReleaseScheduled removes id from the schedule and pushes data onto queueKey
+// together with the status fields, ok is false if somebody else removed it first.
+func (db *DB) ReleaseScheduled(scheduleKey, bodyKey, queueKey, id string, data []byte, statusKey string, expire int, fields ...interface{}) (ok bool, err error) {
+	conn := db.r.Get()
+	defer conn.Close()
+
+	args := append([]interface{}{scheduleKey, bodyKey, queueKey, statusKey, id, data, expire}, fields...)
+	n, err := redis.Int(releaseScheduledScript.Do(conn, args...))
+	ok = n == 1
+	return
+}
@@ -6,19 +6,100 @@ const (
 	PubKey = "public_key"
 	PrivateKey = "private_key"
 	Reward = "vest"
+	AccountStatus = "status"
+	// "1" if the private key was derived from the key seed
+	KeyDerived = "key_derived"
+	// unix seconds the account or post record was written, and a repair was queued
+	Created = "created"
+	RepairTime = "repair_time"
+
+	// account status
+	AccountPending = "pending"
+	AccountOnChain = "on_chain"
+	AccountFailed = "failed"
+	AccountRepaired = "repaired"
+	AccountUnknown = "unknown" // recorded before the status was kept
 
 	//post
 	UUID = "uuid"
 	Owner = "id"
 	ParentId = "parent_id"
+	Title = "title"
+	Content = "content"
+	Tag = "tag"
 
 	// block height
 	BlockHeight = "blockheight"
 
-	// app type str
-	PGStr = "PG"
-	ContentosStr = "CT"
-	Game2048Str = "G2"
+	// account name policy
+	NameTranslit = "translit"
+	NameStrip = "strip"
+	NameRandom = "random"
+
+	// who signs the contract calls of an app
+	CallerUser = "user"
+	CallerTransfer = "transfer"
+
+	// account names claimed by an id
+	NameRegistryPrefix = "namereg:"
+
+	// bumped when the chain is found reset
+	ChainEpoch = "chainepoch"
+
+	// leader lease for singleton background jobs
+	LeaderLease = "leader"
+
+	// job queue and rate limit mode
+	QueueLocal = "local"
+	QueueRedis = "redis"
+
+	// request nonces for replay protection
+	NoncePrefix = "nonce:"
+
+	// rate limit buckets
+	RateLimitPrefix = "ratelimit:"
+
+	// distributed job queue
+	Instances = "instances"
+	QueuePrefix = "queue:"
+	ProcessingPrefix = "processing:"
+	PartitionOwnerPrefix = "partitionowner:"
+
+	// request status
+	StatusPrefix = "status:"
+	Status = "status"
+	MsgType = "type"
+	MsgApp = "app"
+	StatusQueued = "queued"
+	StatusProcessed = "processed"
+	StatusExpired = "expired"
+	StatusScheduled = "scheduled"
+	StatusCancelled = "cancelled"
+	StatusDead = "dead"
+
+	// messages that crashed a worker
+	DeadLetter = "deadletter"
+
+	// transactions broadcast for a request, request:op:signer:n -> hash:expiration
+	TxJournalPrefix = "txjournal:"
+
+	// delayed messages
+	Schedule = "schedule"
+	ScheduleBody = "schedulebody"
+	// post ids held by scheduled posts, post id -> account
+	PostReservePrefix = "postreserve:"
+
+	// last redis to chain reconciliation report
+	ReconcileReport = "reconcilereport"
+
+	// reward correction audit list
+	RewardAudit = "rewardaudit"
+	// rewards credited per block, id -> amount
+	RewardLedgerPrefix = "rewardledger:"
+	// first block height recorded in the reward ledger
+	RewardLedgerStart = "rewardledgerstart"
+	// held while a full reward recomputation runs, the reward job waits
+	RewardPause = "rewardpause"
 
 	// api prefix str
 	IdPrefix = "I"
@@ -10,9 +10,11 @@ require (
 	github.com/hashicorp/golang-lru v0.5.1
 	github.com/itchyny/base58-go v0.0.0-20181013094353-56d50cf40874
 	github.com/jinzhu/configor v1.0.0
+	github.com/mozillazg/go-pinyin v0.15.0
 	github.com/pkg/errors v0.8.0
 	github.com/sirupsen/logrus v1.4.0
 	golang.org/x/net v0.0.0-20190311183353-d8887717615a
+	golang.org/x/text v0.3.0
 	golang.org/x/time v0.0.0-20190308202827-9d24e82272b4
 	google.golang.org/grpc v1.16.0
 )
@@ -0,0 +1,92 @@
+package job
+
+import (
+	"fmt"
+	"github.com/hashicorp/golang-lru"
+	"proxy/database"
+	"proxy/define"
+	"time"
+)
+
+const (
+	knownAccountSize   = 100000
+	knownAccountTime   = 5 * time.Minute
+	missingAccountTime = 10 * time.Second
+	chainEpochInterval = 10 * time.Second
+)
+
+/**
+ * 链上账号缓存
+ *   链上查到的账号可能还未不可逆，存在的账号缓存 knownAccountTime，不存在的只缓存很短时间；
+ *   链被重置时主节点增加 redis 中的 chain epoch，各实例发现变化后清空缓存。
+ */
+type knownAccount struct {
+	exists  bool
+	expires time.Time
+}
+
+var knownAccounts *lru.Cache
+
+func init() {
+	cache, err := lru.New(knownAccountSize)
+	if err != nil {
+		panic(err)
+	}
+	knownAccounts = cache
+}
+
+// cachedAccount reports whether name is on chain, ok is false if unknown.
+func cachedAccount(name string) (exists bool, ok bool) {
+	v, ok := knownAccounts.Get(name)
+	if !ok {
+		return false, false
+	}
+	a := v.(*knownAccount)
+	if time.Now().After(a.expires) {
+		knownAccounts.Remove(name)
+		return false, false
+	}
+	return a.exists, true
+}
+
+func rememberAccount(name string, exists bool) {
+	ttl := knownAccountTime
+	if !exists {
+		ttl = missingAccountTime
+	}
+	knownAccounts.Add(name, &knownAccount{exists: exists, expires: time.Now().Add(ttl)})
+}
+
+func forgetAccount(name string) {
+	knownAccounts.Remove(name)
+}
+
+// chainReset tells every instance to drop its cached accounts.
+func chainReset(db *database.DB) {
+	knownAccounts.Purge()
+	if _, err := db.INCR(define.ChainEpoch); err != nil {
+		log.Error(fmt.Sprintf("INCR %v error:%v", define.ChainEpoch, err))
+	}
+}
+
+// WatchChainReset drops the cached accounts when another instance saw the
+// chain reset, purge drops the caches kept outside the job package.
+func WatchChainReset(db *database.DB, purge func()) {
+	var epoch uint64
+	for {
+		n, err := db.GETUint64(define.ChainEpoch)
+		if err != nil {
+			log.Error(fmt.Sprintf("GET %v error:%v", define.ChainEpoch, err))
+		} else if n != epoch {
+			if epoch != 0 {
+				log.Info(fmt.Sprintf("chain epoch:%v drop cached accounts", n))
+			}
+			knownAccounts.Purge()
+			if purge != nil {
+				purge()
+			}
+			epoch = n
+		}
+		time.Sleep(chainEpochInterval)
+	}
+}
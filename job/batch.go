@@ -0,0 +1,84 @@
+package job
+
+import (
+	"fmt"
+	"proxy/utils"
+	"strings"
+)
+
+/**
+ * 交易合并
+ *   链上交易只能有一个签名，因此只把相邻且签名账号相同的操作合并进同一笔交易，
+ *   既减少 rpc 往返，也让这些操作要么一起成功要么一起失败。
+ *   不相邻的操作不合并，以免改变执行顺序。
+ *   官号充值与用户自己签名的合约调用无法合并；app 配置 ContractCaller: transfer 时
+ *   合约由转账账号代为调用，签到等操作只需要一笔交易（见 userContractCall）。
+ */
+type txBatch struct {
+	groups []*txGroup
+}
+
+type txGroup struct {
+	id      string
+	signer  string
+	privKey string
+	opNames []string
+	ops     []interface{}
+	done    []func(ok bool)
+}
+
+func (b *txBatch) add(id, signer, privKey, opName string, op interface{}) {
+	if n := len(b.groups); n > 0 && b.groups[n-1].signer == signer {
+		g := b.groups[n-1]
+		g.opNames = append(g.opNames, opName)
+		g.ops = append(g.ops, op)
+		return
+	}
+	b.groups = append(b.groups, &txGroup{
+		id:      id,
+		signer:  signer,
+		privKey: privKey,
+		opNames: []string{opName},
+		ops:     []interface{}{op},
+	})
+}
+
+// onDone registers fn to learn the outcome of the operation added last.
+func (b *txBatch) onDone(fn func(ok bool)) {
+	if n := len(b.groups); n > 0 {
+		g := b.groups[n-1]
+		g.done = append(g.done, fn)
+	}
+}
+
+// flush broadcasts one transaction per group in order and stops at the
+// first failure, the groups not sent count as failed.
+func (j *Job) flush(b *txBatch) bool {
+	for len(b.groups) > 0 {
+		g := b.groups[0]
+		signTx, err := utils.GenerateSignedTx(g.privKey, j.rpcPool.GetClient(), g.ops...)
+		if err != nil {
+			log.Error(fmt.Sprintf("GenerateSignedTx error:%v", err))
+			b.fail()
+			return false
+		}
+		if !j.call(g.id, g.signer, strings.Join(g.opNames, "+"), signTx) {
+			b.fail()
+			return false
+		}
+		for _, fn := range g.done {
+			fn(true)
+		}
+		b.groups = b.groups[1:]
+	}
+	return true
+}
+
+func (b *txBatch) fail() {
+	for _, g := range b.groups {
+		for _, fn := range g.done {
+			fn(false)
+		}
+	}
+	b.groups = nil
+}
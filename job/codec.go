@@ -0,0 +1,70 @@
+package job
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// envelope is the wire format of a message kept in redis.
+type envelope struct {
+	Type string
+	Key  uint64
+	Body json.RawMessage
+}
+
+var msgTypes = map[string]reflect.Type{}
+
+func init() {
+	for _, m := range []interface{}{
+		&AccountMsg{},
+		&PostMsg{},
+		&RepairPostMsg{},
+		&LikeMsg{},
+		&CommentMsg{},
+		&FollowMsg{},
+		&FakeLikeMsg{},
+		&FakeCommentMsg{},
+		&SignInMsg{},
+		&Game2048Msg{},
+	} {
+		t := reflect.TypeOf(m).Elem()
+		msgTypes[t.Name()] = t
+	}
+}
+
+func msgTypeName(m interface{}) string {
+	t := reflect.TypeOf(m)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Name()
+}
+
+func encodeMsg(key uint64, m interface{}) ([]byte, error) {
+	name := msgTypeName(m)
+	if _, ok := msgTypes[name]; !ok {
+		return nil, fmt.Errorf("unknown message type:%v", name)
+	}
+	body, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(&envelope{Type: name, Key: key, Body: body})
+}
+
+func decodeMsg(data []byte) (uint64, interface{}, error) {
+	e := &envelope{}
+	if err := json.Unmarshal(data, e); err != nil {
+		return 0, nil, err
+	}
+	t, ok := msgTypes[e.Type]
+	if !ok {
+		return 0, nil, fmt.Errorf("unknown message type:%v", e.Type)
+	}
+	m := reflect.New(t).Interface()
+	if err := json.Unmarshal(e.Body, m); err != nil {
+		return 0, nil, err
+	}
+	return e.Key, m, nil
+}
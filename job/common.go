@@ -8,6 +8,7 @@ import (
 	"proxy/define"
 	"proxy/utils"
 	"strconv"
+	"time"
 )
 
 /**
@@ -22,8 +23,7 @@ type LoserTransferWinnerOption struct {
 	Memo     string // 转账说明
 }
 
-func (j *Job) loserTransferToWinner(option *LoserTransferWinnerOption) bool {
-
+func (j *Job) addLoserTransfer(b *txBatch, option *LoserTransferWinnerOption) {
 	memo := utils.GenerateUUID(option.Lname)
 	transOp := &prototype.TransferOperation{
 		From:   &prototype.AccountName{Value: option.Lname},
@@ -31,17 +31,8 @@ func (j *Job) loserTransferToWinner(option *LoserTransferWinnerOption) bool {
 		Amount: &prototype.Coin{Value: option.Cosnum},
 		Memo:   strconv.Itoa(int(memo)),
 	}
-	signTx1, err := utils.GenerateSignedTx(option.Lprivkey, j.rpcPool.GetClient(), transOp)
-	if err != nil {
-		log.Error(fmt.Sprintf("GenerateSignedTx error:%v", err))
-		return false
-	}
-	if !j.call(option.Lid, option.Lname, "loserTransferToWinner", signTx1) {
-		log.Error(fmt.Sprintf("loserTransferToWinner error:%v", err))
-		return false
-	}
+	b.add(option.Lid, option.Lname, option.Lprivkey, "loserTransferToWinner", transOp)
 	log.Info(fmt.Sprintf("loserTransferToWinnerInfo: transOp:%v", transOp))
-	return true
 }
 
 /**
@@ -50,13 +41,15 @@ func (j *Job) loserTransferToWinner(option *LoserTransferWinnerOption) bool {
  *   如果一个账号的剩余cos数量超过0.3cos，将不再赠送
  */
 type TransferOption struct {
-	id    string // 用户id
-	name  string // 用户昵称
-	val   uint64 // 赠送数量
-	limit uint64 // 限制（用户cos数量超过多少之后，不给赠送）
+	app   *config.App // 出资的app
+	id    string      // 用户id
+	name  string      // 用户昵称
+	val   uint64      // 赠送数量
+	limit uint64      // 限制（用户cos数量超过多少之后，不给赠送）
 }
 
-func (j *Job) transferTo(option *TransferOption) bool {
+// addTransfer adds nothing if the account already holds option.limit.
+func (j *Job) addTransfer(b *txBatch, option *TransferOption) bool {
 
 	if info, err := j.getAccountInfo(option.name); err != false && info != nil && option.limit > 0 {
 		if coin := info.GetInfo().GetCoin().GetValue(); coin >= option.limit {
@@ -65,21 +58,12 @@ func (j *Job) transferTo(option *TransferOption) bool {
 		}
 	}
 
-	conf := config.GetConfig()
 	transOp := &prototype.TransferOperation{
-		From:   &prototype.AccountName{Value: conf.TransferName},
+		From:   &prototype.AccountName{Value: option.app.TransferName},
 		To:     &prototype.AccountName{Value: option.name},
 		Amount: &prototype.Coin{Value: option.val},
 	}
-	signTx1, err := utils.GenerateSignedTx(conf.TransferPriKey, j.rpcPool.GetClient(), transOp)
-	if err != nil {
-		log.Error(fmt.Sprintf("GenerateSignedTx error:%v", err))
-		return false
-	}
-	if !j.call(option.id, conf.TransferName, "transfer", signTx1) {
-		log.Error(fmt.Sprintf("transfer error:%v", err))
-		return false
-	}
+	b.add(option.id, option.app.TransferName, option.app.TransferPriKey, "transfer", transOp)
 	return true
 }
 
@@ -96,10 +80,22 @@ func (j *Job) getAccountInfo(accountName string) (*grpcpb.AccountResponse, bool)
 		c.SetAlive(false)
 		return nil, false
 	}
+	rememberAccount(accountName, resp.GetInfo().GetAccountName() != nil)
 	return resp, true
 }
 
 func (j *Job) accountExistInChain(accountName string) bool {
+	exists, _ := j.lookupAccount(accountName)
+	return exists
+}
+
+// lookupAccount tells whether the chain has the account, err is set when the
+// node could not be asked.
+func (j *Job) lookupAccount(accountName string) (bool, error) {
+	if exists, ok := cachedAccount(accountName); ok {
+		return exists, nil
+	}
+
 	// query account if exists in chain
 	getAccount := &grpcpb.GetAccountByNameRequest{
 		AccountName: &prototype.AccountName{Value: accountName},
@@ -109,20 +105,41 @@ func (j *Job) accountExistInChain(accountName string) bool {
 	if err != nil {
 		log.Error(fmt.Sprintf("rpc GetAccountByName error:%v", err))
 		c.SetAlive(false)
-		return false
+		return false, err
 	}
-	if resp.Info.AccountName != nil {
+	if resp.GetInfo().GetAccountName() != nil {
 		log.Info(fmt.Sprintf("rpc GetAccountByName account still on the chain:%v", resp.Info.AccountName))
-		return true
+		rememberAccount(accountName, true)
+		return true, nil
 	}
-	return false
+	rememberAccount(accountName, false)
+	return false, nil
 }
 
 func (j *Job) call(uid, name, opType string, signTx *prototype.SignedTransaction) bool {
 	req := &grpcpb.BroadcastTrxRequest{Transaction: signTx}
-	c := j.rpcPool.GetClient()
 	id, _ := signTx.Id()
 	idStr := fmt.Sprintf("%x", id.Hash)
+
+	// a replayed message must not broadcast what already made it to chain
+	key := j.journalKey(opType, name)
+	if key != "" {
+		done, err := j.replayed(key)
+		if err != nil {
+			log.Error(fmt.Sprintf("job_%v journal %v error:%v", j.index, key, err))
+			return false
+		}
+		if done {
+			log.Info(fmt.Sprintf("job_%v skip broadcast id:%v name:%v op:%v, on chain already", j.index, uid, name, opType))
+			return true
+		}
+		if err := j.journal(key, id.Hash, signTx); err != nil {
+			log.Error(fmt.Sprintf("job_%v journal %v error:%v", j.index, key, err))
+			return false
+		}
+	}
+
+	c := j.rpcPool.GetClient()
 	res, err := c.BroadcastTrx(req)
 	if err != nil || res == nil {
 		log.Error(fmt.Sprintf("job_%v broadcast id:%v name:%v op:%v error:%v res:%v hash:%v", j.index, uid, name, opType, err, res, idStr))
@@ -131,6 +148,12 @@ func (j *Job) call(uid, name, opType string, signTx *prototype.SignedTransaction
 	} else {
 		if res.Invoice.Status != 200 {
 			log.Error(fmt.Sprintf("job_%v broadcast id:%v name:%v op:%v res status error:%v hash:%v res:%v", j.index, uid, name, opType, err, idStr, res))
+			// rejected for sure, a retry need not wait for it to expire
+			if key != "" {
+				if err := j.db.DEL(key); err != nil {
+					log.Error(fmt.Sprintf("job_%v DEL %v error:%v", j.index, key, err))
+				}
+			}
 			return false
 		}
 		log.Info(fmt.Sprintf("job_%v broadcast id:%v name:%v op:%v response:%v hash:%v", j.index, uid, name, opType, res, idStr))
@@ -138,17 +161,20 @@ func (j *Job) call(uid, name, opType string, signTx *prototype.SignedTransaction
 	}
 }
 
-func (j *Job) callContract(id, name, opName, contract, method, param string) bool {
-	conf := config.GetConfig()
-	applyOp := &prototype.ContractApplyOperation{
-		Caller:   &prototype.AccountName{Value: name},
-		Owner:    &prototype.AccountName{Value: conf.ContractDeployerName},
+func contractOp(caller string, app *config.App, method, param string) *prototype.ContractApplyOperation {
+	return &prototype.ContractApplyOperation{
+		Caller:   &prototype.AccountName{Value: caller},
+		Owner:    &prototype.AccountName{Value: app.ContractDeployerName},
 		Amount:   &prototype.Coin{Value: 0},
 		Gas:      &prototype.Coin{Value: 300000},
-		Contract: contract,
+		Contract: app.ContractName,
 		Params:   param,
 		Method:   method,
 	}
+}
+
+func (j *Job) callContract(id, name, opName string, app *config.App, method, param string) bool {
+	applyOp := contractOp(name, app, method, param)
 
 	privKeyStr, err := j.db.HGETString(id, define.PrivateKey)
 	if err != nil || privKeyStr == "" {
@@ -165,21 +191,62 @@ func (j *Job) callContract(id, name, opName, contract, method, param string) boo
 }
 
 func (j *Job) createAccount(id, name, app string) bool {
-	// generate prikey and pubkey
-	pubKeyStr, privKeyStr, err := utils.GenerateNewKey()
-
-	// we just record info in proxy,if chain failed, we can repair chain via info when subsequent PG's request come
-	if err := j.db.SetAccount(id, define.Name, name, define.PubKey, pubKeyStr, define.PrivateKey, privKeyStr); err != nil {
-		log.Error(fmt.Sprintf("SetAccount error:%v", err))
+	b := &txBatch{}
+	if !j.addCreateAccount(b, id, name, app) {
 		return false
 	}
+	return j.flush(b)
+}
 
-	conf := config.GetConfig()
-	creator := conf.CreatorMap[app]
+func (j *Job) addCreateAccount(b *txBatch, id, name, app string) bool {
+	creator := getApp(app)
 	if creator == nil {
-		log.Error(fmt.Sprintf("can not found creator for:%v", app))
 		return false
 	}
+	// a record that exists already is a repair
+	record, err := j.db.HGETALL(id)
+	if err != nil {
+		log.Error(fmt.Sprintf("HGETALL error:%v account:%v", err, id))
+		return false
+	}
+	repair := len(record) > 0
+
+	// a stored key is kept, the chain may already have the account with it
+	privKeyStr := record[define.PrivateKey]
+	var pubKeyStr string
+	derived := false
+	if privKeyStr != "" {
+		privKey, err := prototype.PrivateKeyFromWIF(privKeyStr)
+		if err != nil {
+			log.Error(fmt.Sprintf("stored private key invalid error:%v account:%v", err, id))
+			return false
+		}
+		pubKey, err := privKey.PubKey()
+		if err != nil {
+			log.Error(fmt.Sprintf("stored private key invalid error:%v account:%v", err, id))
+			return false
+		}
+		pubKeyStr = pubKey.ToWIF()
+	} else if pubKeyStr, privKeyStr, derived, err = newUserKey(id, creator); err != nil {
+		log.Error(fmt.Sprintf("newUserKey error:%v account:%v", err, id))
+		return false
+	}
+
+	// we just record info in proxy,if chain failed, we can repair chain via info when subsequent PG's request come
+	fields := []interface{}{define.Name, name, define.PubKey, pubKeyStr, define.PrivateKey, privKeyStr}
+	if !repair {
+		fields = append(fields, define.Created, time.Now().Unix())
+	}
+	// verifykeys only expects the marked keys to match the seed
+	if derived {
+		fields = append(fields, define.KeyDerived, 1)
+	}
+	if err := j.db.HMSET(id, fields...); err != nil {
+		log.Error(fmt.Sprintf("SetAccount error:%v", err))
+		return false
+	}
+	j.setAccountStatus(id, define.AccountPending)
+
 	// write to chain
 	pubkey, _ := prototype.PublicKeyFromWIF(pubKeyStr)
 	acop := &prototype.AccountCreateOperation{
@@ -188,12 +255,35 @@ func (j *Job) createAccount(id, name, app string) bool {
 		NewAccountName: &prototype.AccountName{Value: name},
 		Owner:          pubkey,
 	}
-	signTx, err := utils.GenerateSignedTx(creator.CreatorPriKey, j.rpcPool.GetClient(), acop)
-	if err != nil {
-		log.Error(fmt.Sprintf("GenerateSignedTx error:%v", err))
-		return false
+	b.add(id, creator.CreatorName, creator.CreatorPriKey, "accountcreate", acop)
+	b.onDone(func(ok bool) {
+		// not irreversible yet, the next lookup asks the chain
+		forgetAccount(name)
+		switch {
+		case !ok:
+			j.setAccountStatus(id, define.AccountFailed)
+		case repair:
+			j.setAccountStatus(id, define.AccountRepaired)
+		default:
+			j.setAccountStatus(id, define.AccountOnChain)
+		}
+	})
+	return true
+}
+
+func (j *Job) setAccountStatus(id, status string) {
+	if err := j.db.HSET(id, define.AccountStatus, status); err != nil {
+		log.Error(fmt.Sprintf("set account:%v status:%v error:%v", id, status, err))
+	}
+}
+
+// getApp returns the registered app a message belongs to.
+func getApp(name string) *config.App {
+	app := config.GetConfig().AppNameMap[name]
+	if app == nil {
+		log.Error(fmt.Sprintf("can not found app:%v", name))
 	}
-	return j.call(id, name, "accountcreate", signTx)
+	return app
 }
 
 func (j *Job) GetUserActionList(accountName string) (*grpcpb.GetUserTrxListByTimeResponse, bool) {
@@ -0,0 +1,122 @@
+package job
+
+import (
+	"encoding/json"
+	"fmt"
+	"proxy/config"
+	"proxy/database"
+	"proxy/define"
+	"time"
+)
+
+/**
+ * 死信
+ *   处理时 panic 的消息连同原因与调用栈一起保存，供排查与重新投递
+ */
+type DeadLetter struct {
+	RequestId string
+	Type      string
+	Msg       json.RawMessage // encoded message, same format as the redis queue
+	Error     string
+	Stack     string
+	Instance  string
+	Time      int64
+}
+
+func addDeadLetter(db *database.DB, key uint64, m interface{}, reason, stack string) {
+	msgType := msgTypeName(m)
+	requestId := ""
+	if t, ok := m.(traced); ok {
+		requestId = t.trace().RequestId
+	}
+	log.Error(fmt.Sprintf("dead letter request:%v type:%v error:%v msg:%+v\n%v", requestId, msgType, reason, m, stack))
+
+	d := &DeadLetter{
+		RequestId: requestId,
+		Type:      msgType,
+		Error:     reason,
+		Stack:     stack,
+		Instance:  config.GetConfig().InstanceId,
+		Time:      time.Now().Unix(),
+	}
+	if data, err := encodeMsg(key, m); err == nil {
+		d.Msg = data
+	}
+	data, err := json.Marshal(d)
+	if err != nil {
+		log.Error(fmt.Sprintf("encode dead letter request:%v error:%v", requestId, err))
+		return
+	}
+	if err := db.LPUSH(define.DeadLetter, data); err != nil {
+		log.Error(fmt.Sprintf("LPUSH dead letter request:%v error:%v", requestId, err))
+	}
+	setStatus(db, requestId, m, define.StatusDead)
+}
+
+// DeadLetters lists dead letters, newest first.
+func DeadLetters(db *database.DB, start, stop int) ([]*DeadLetter, error) {
+	list, err := db.LRANGE(define.DeadLetter, start, stop)
+	if err != nil {
+		return nil, err
+	}
+	var res []*DeadLetter
+	for _, data := range list {
+		d := &DeadLetter{}
+		if err := json.Unmarshal(data, d); err != nil {
+			log.Error(fmt.Sprintf("decode dead letter error:%v data:%s", err, data))
+			continue
+		}
+		res = append(res, d)
+	}
+	return res, nil
+}
+
+// takeDeadLetter removes the dead letter of requestId from the list.
+func takeDeadLetter(db *database.DB, requestId string) (*DeadLetter, error) {
+	list, err := db.LRANGE(define.DeadLetter, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	for _, data := range list {
+		d := &DeadLetter{}
+		if err := json.Unmarshal(data, d); err != nil || d.RequestId != requestId {
+			continue
+		}
+		n, err := db.LREM(define.DeadLetter, 1, data)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			// somebody else took it meanwhile
+			return nil, nil
+		}
+		return d, nil
+	}
+	return nil, nil
+}
+
+// RequeueDeadLetter sends the message of requestId through the dispatcher
+// again. It returns false if there is no such dead letter.
+func RequeueDeadLetter(db *database.DB, dispatcher Dispatcher, requestId string) (bool, error) {
+	d, err := takeDeadLetter(db, requestId)
+	if err != nil || d == nil {
+		return false, err
+	}
+	key, m, err := decodeMsg(d.Msg)
+	if err != nil {
+		return true, err
+	}
+	// restamp so the deadline counts from now
+	if t, ok := m.(traced); ok {
+		tr := t.trace()
+		tr.EnqueueTime = 0
+		tr.Deadline = 0
+	}
+	return true, dispatcher.Dispatch(key, m)
+}
+
+// DiscardDeadLetter drops the dead letter of requestId for good.
+func DiscardDeadLetter(db *database.DB, requestId string) (bool, error) {
+	d, err := takeDeadLetter(db, requestId)
+	return d != nil, err
+}
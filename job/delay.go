@@ -0,0 +1,140 @@
+package job
+
+import (
+	"fmt"
+	"proxy/database"
+	"proxy/define"
+	"time"
+)
+
+const delayBatch = 100
+
+/**
+ * 延时消息
+ *   消息先存入 redis 有序集合（score 为最早执行时间），到期后由主节点取出交给分发器；
+ *   到期前可以通过 request id 取消。
+ */
+type DelayQueue struct {
+	db         *database.DB
+	dispatcher Dispatcher
+	leader     *Leader
+}
+
+func NewDelayQueue(db *database.DB, d Dispatcher, leader *Leader) *DelayQueue {
+	return &DelayQueue{db: db, dispatcher: d, leader: leader}
+}
+
+// Schedule keeps m until notBefore and returns its request id.
+func (q *DelayQueue) Schedule(key uint64, m interface{}, notBefore time.Time) (string, error) {
+	t, ok := m.(traced)
+	if !ok {
+		return "", fmt.Errorf("message type:%v can not be scheduled", msgTypeName(m))
+	}
+	tr := t.trace()
+	if tr.RequestId == "" {
+		tr.RequestId = newRequestId()
+	}
+	data, err := encodeMsg(key, m)
+	if err != nil {
+		return "", err
+	}
+	// body first, so the poller never sees an id without one
+	if err := q.db.HSET(define.ScheduleBody, tr.RequestId, data); err != nil {
+		return "", err
+	}
+	if err := q.db.ZADD(define.Schedule, notBefore.Unix(), tr.RequestId); err != nil {
+		return "", err
+	}
+	// the record outlives the wait, however far ahead the message is due
+	expire := int(time.Until(notBefore)/time.Second) + statusExpire
+	if err := q.db.SetStatus(define.StatusPrefix+tr.RequestId, expire, statusFields(m, define.StatusScheduled)...); err != nil {
+		log.Error(fmt.Sprintf("SetStatus request:%v status:%v error:%v", tr.RequestId, define.StatusScheduled, err))
+	}
+	return tr.RequestId, nil
+}
+
+// Cancel drops a message of app that is not due yet and returns it. It
+// returns nil if the request is unknown, belongs to another app or has
+// already been handed to the workers.
+func (q *DelayQueue) Cancel(requestId, app string) (interface{}, error) {
+	data, err := q.db.HGETBytes(define.ScheduleBody, requestId)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	_, m, err := decodeMsg(data)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := m.(traced); !ok || t.trace().AppStr != app {
+		return nil, nil
+	}
+	ok, err := q.db.ReleaseScheduled(define.Schedule, define.ScheduleBody, "", requestId, nil, "", 0)
+	if err != nil || !ok {
+		return nil, err
+	}
+	setStatus(q.db, requestId, m, define.StatusCancelled)
+	return m, nil
+}
+
+func (q *DelayQueue) Start() {
+	for {
+		time.Sleep(time.Second)
+		if !q.leader.IsLeader() {
+			continue
+		}
+		q.poll()
+	}
+}
+
+func (q *DelayQueue) poll() {
+	ids, err := q.db.ZRANGEBYSCORE(define.Schedule, "-inf", time.Now().Unix(), delayBatch)
+	if err != nil {
+		log.Error(fmt.Sprintf("ZRANGEBYSCORE %v error:%v", define.Schedule, err))
+		return
+	}
+	for _, id := range ids {
+		if err := q.release(id); err != nil {
+			log.Error(fmt.Sprintf("release scheduled request:%v error:%v", id, err))
+		}
+	}
+}
+
+/**
+ * 释放到期的消息
+ *   redis 队列模式下移出有序集合与写入分区队列在同一个脚本中完成，进程崩溃不会丢消息；
+ *   本地队列模式下消息本来就只在进程内，移出后直接交给调度器
+ */
+func (q *DelayQueue) release(id string) error {
+	data, err := q.db.HGETBytes(define.ScheduleBody, id)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		// cancelled meanwhile, or an id left without a body
+		_, err := q.db.ZREM(define.Schedule, id)
+		return err
+	}
+	key, m, err := decodeMsg(data)
+	if err != nil {
+		// undecodable, it can never be released
+		q.db.ZREM(define.Schedule, id)
+		return err
+	}
+
+	d, ok := q.dispatcher.(*redisDispatcher)
+	if !ok {
+		// whoever removes the id owns it, a concurrent cancel may win
+		if ok, err := q.db.ReleaseScheduled(define.Schedule, define.ScheduleBody, "", id, nil, "", 0); err != nil || !ok {
+			return err
+		}
+		return q.dispatcher.Dispatch(key, m)
+	}
+
+	stamp(m)
+	if data, err = encodeMsg(key, m); err != nil {
+		return err
+	}
+	_, err = q.db.ReleaseScheduled(define.Schedule, define.ScheduleBody, d.queue(key), id, data,
+		define.StatusPrefix+id, statusExpire, statusFields(m, define.StatusQueued)...)
+	return err
+}
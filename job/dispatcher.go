@@ -0,0 +1,81 @@
+package job
+
+import (
+	"fmt"
+	"proxy/database"
+	"proxy/define"
+)
+
+/**
+ * 消息分发
+ *   同一个用户的消息必须按顺序处理，key 一般为用户 uid
+ */
+type Dispatcher interface {
+	Dispatch(key uint64, m interface{}) error
+}
+
+// localDispatcher hands a message straight to the in-process scheduler.
+type localDispatcher struct {
+	db        *database.DB
+	scheduler *Scheduler
+}
+
+func NewLocalDispatcher(db *database.DB, s *Scheduler) Dispatcher {
+	return &localDispatcher{db: db, scheduler: s}
+}
+
+func (d *localDispatcher) Dispatch(key uint64, m interface{}) error {
+	stamp(m)
+	setStatus(d.db, requestIdOf(m), m, define.StatusQueued)
+	d.scheduler.Put(key, m, nil)
+	return nil
+}
+
+// redisDispatcher routes a user to one redis partition, which is consumed by
+// exactly one proxy instance at a time.
+type redisDispatcher struct {
+	db    *database.DB
+	count int
+}
+
+func NewRedisDispatcher(db *database.DB, partitionCount int) Dispatcher {
+	return &redisDispatcher{db: db, count: partitionCount}
+}
+
+func (d *redisDispatcher) Dispatch(key uint64, m interface{}) error {
+	stamp(m)
+	data, err := encodeMsg(key, m)
+	if err != nil {
+		return err
+	}
+	// queued together with the push, a fast consumer may already mark it
+	// processed and a failed push leaves no status behind
+	requestId := requestIdOf(m)
+	if requestId == "" {
+		return d.db.LPUSH(d.queue(key), data)
+	}
+	return d.db.PushStatus(d.queue(key), data, define.StatusPrefix+requestId, statusExpire, statusFields(m, define.StatusQueued)...)
+}
+
+func (d *redisDispatcher) queue(key uint64) string {
+	return partitionQueue(int(key % uint64(d.count)))
+}
+
+func requestIdOf(m interface{}) string {
+	if t, ok := m.(traced); ok {
+		return t.trace().RequestId
+	}
+	return ""
+}
+
+func partitionQueue(part int) string {
+	return fmt.Sprintf("%v%v", define.QueuePrefix, part)
+}
+
+func partitionProcessing(part int) string {
+	return fmt.Sprintf("%v%v", define.ProcessingPrefix, part)
+}
+
+func partitionOwner(part int) string {
+	return fmt.Sprintf("%v%v", define.PartitionOwnerPrefix, part)
+}
@@ -0,0 +1,147 @@
+package job
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+type WorkerStat struct {
+	Index   int
+	Running bool
+	Paused  bool
+	Key     uint64 // user being processed
+	Type    string // message being processed
+}
+
+type SchedulerStat struct {
+	Workers       int
+	Busy          int
+	Pending       int
+	Users         int
+	Lanes         map[string]int
+	PausedTypes   []string
+	PausedWorkers []int
+	Jobs          []*WorkerStat
+}
+
+type PendingMsg struct {
+	Key       uint64
+	Lane      string
+	Type      string
+	RequestId string
+	Age       string
+	Msg       interface{}
+}
+
+func laneName(lane int) string {
+	for name, l := range laneNames {
+		if l == lane {
+			return name
+		}
+	}
+	return ""
+}
+
+// Stat reports lane depths and what every worker is doing.
+func (s *Scheduler) Stat() *SchedulerStat {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	st := &SchedulerStat{
+		Workers: s.workers(),
+		Busy:    s.busy,
+		Pending: s.pending,
+		Users:   len(s.users),
+		Lanes:   make(map[string]int),
+	}
+	for lane, r := range s.ready {
+		st.Lanes[laneName(lane)] = len(r)
+	}
+	for t := range s.pausedTypes {
+		st.PausedTypes = append(st.PausedTypes, t)
+	}
+	sort.Strings(st.PausedTypes)
+	for i := range s.pausedWorkers {
+		st.PausedWorkers = append(st.PausedWorkers, i)
+	}
+	sort.Ints(st.PausedWorkers)
+
+	spare := make(map[int]bool)
+	for _, j := range s.spare {
+		spare[j.index] = true
+	}
+	for _, j := range s.jobs {
+		w := &WorkerStat{Index: j.index, Running: !spare[j.index], Paused: s.pausedWorkers[j.index]}
+		if u := s.current[j.index]; u != nil {
+			w.Key = u.key
+			w.Type = msgTypeName(u.tasks[0].msg)
+		}
+		st.Jobs = append(st.Jobs, w)
+	}
+	return st
+}
+
+// Peek returns up to limit waiting messages per lane, in the order they
+// will be taken.
+func (s *Scheduler) Peek(limit int) []*PendingMsg {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	var list []*PendingMsg
+	for lane, r := range s.ready {
+		for i := 0; i < len(r) && i < limit; i++ {
+			m := r[i].tasks[0].msg
+			p := &PendingMsg{Key: r[i].key, Lane: laneName(lane), Type: msgTypeName(m), Msg: m}
+			if t, ok := m.(traced); ok {
+				p.RequestId = t.trace().RequestId
+				p.Age = now.Sub(time.Unix(0, t.trace().EnqueueTime)).String()
+			}
+			list = append(list, p)
+		}
+	}
+	return list
+}
+
+// PauseType stops workers from taking messages of msgType until resumed.
+// Users whose next message has that type wait, keeping their order.
+func (s *Scheduler) PauseType(msgType string, paused bool) error {
+	if _, ok := msgTypes[msgType]; !ok {
+		return fmt.Errorf("unknown message type:%v", msgType)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if paused {
+		s.pausedTypes[msgType] = true
+	} else {
+		delete(s.pausedTypes, msgType)
+	}
+	s.notEmpty.Broadcast()
+	return nil
+}
+
+// PauseWorker lets worker index finish its current message and then idle.
+func (s *Scheduler) PauseWorker(index int, paused bool) error {
+	if index < 0 || index >= len(s.jobs) {
+		return fmt.Errorf("unknown worker:%v", index)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if paused {
+		s.pausedWorkers[index] = true
+	} else {
+		delete(s.pausedWorkers, index)
+	}
+	s.notEmpty.Broadcast()
+	return nil
+}
+
+// Pending returns the number of queued and running messages.
+func (s *Scheduler) Pending() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.pending
+}
@@ -1,26 +1,45 @@
 package job
 
 import (
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"os"
 	"proxy/database"
+	"proxy/define"
 	"proxy/rpc"
+	"time"
 )
 
 const (
-	size    = 500
-	tfLimit = uint64(300000)
+	size = 500
 )
 
 type Trace struct {
-	AppStr string
+	AppStr      string
+	RequestId   string
+	EnqueueTime int64 // unix nano
+	Deadline    int64 // unix nano, 0 means never expires
+}
+
+type traced interface {
+	trace() *Trace
+}
+
+func (t *Trace) trace() *Trace {
+	return t
+}
+
+func (t *Trace) expired(now time.Time) bool {
+	return t.Deadline > 0 && now.UnixNano() > t.Deadline
 }
 
 type Job struct {
 	index   int
-	queue   chan interface{}
 	db      *database.DB
 	rpcPool *rpc.RpcPool
+	// request being processed and the transactions sent for it, see journal.go
+	request string
+	sent    map[string]int
 }
 
 var log *logrus.Logger
@@ -36,40 +55,52 @@ func NewJob(db *database.DB, f *os.File, i int, pool *rpc.RpcPool) *Job {
 	log.Out = f
 	log.SetReportCaller(true)
 
-	job := &Job{queue: make(chan interface{}, size)}
+	job := &Job{}
 	job.rpcPool = pool
 	job.db = db
 	job.index = i
 	return job
 }
 
-func (j *Job) Start() {
-	for {
-		msg := <-j.queue
-		switch x := msg.(type) {
-		case *AccountMsg:
-			j.processAccountMsg(x)
-		case *PostMsg:
-			j.processPostMsg(x)
-		case *LikeMsg:
-			j.processLikeMsg(x)
-		case *CommentMsg:
-			j.processCommentMsg(x)
-		case *FollowMsg:
-			j.processFollowMsg(x)
-		case *FakeLikeMsg:
-			j.processFakeLikeMsg(x)
-		case *FakeCommentMsg:
-			j.processFakeCommentMsg(x)
-		case *SignInMsg:
-			j.processSignInMsg(x)
-		case *Game2048Msg:
-			j.processGame2048Msg(x)
-		default:
+func (j *Job) process(msg interface{}) {
+	if t, ok := msg.(traced); ok {
+		tr := t.trace()
+		j.begin(tr.RequestId)
+		defer j.end()
+		now := time.Now()
+		expired := tr.expired(now)
+		observeAge(msgTypeName(msg), now.Sub(time.Unix(0, tr.EnqueueTime)), expired)
+		if expired {
+			log.Error(fmt.Sprintf("job_%v drop expired message request:%v type:%v msg:%+v", j.index, tr.RequestId, msgTypeName(msg), msg))
+			setStatus(j.db, tr.RequestId, msg, define.StatusExpired)
+			return
 		}
 	}
-}
 
-func (j *Job) Put(m interface{}) {
-	j.queue <- m
+	switch x := msg.(type) {
+	case *AccountMsg:
+		j.processAccountMsg(x)
+	case *PostMsg:
+		j.processPostMsg(x)
+	case *RepairPostMsg:
+		j.processRepairPostMsg(x)
+	case *LikeMsg:
+		j.processLikeMsg(x)
+	case *CommentMsg:
+		j.processCommentMsg(x)
+	case *FollowMsg:
+		j.processFollowMsg(x)
+	case *FakeLikeMsg:
+		j.processFakeLikeMsg(x)
+	case *FakeCommentMsg:
+		j.processFakeCommentMsg(x)
+	case *SignInMsg:
+		j.processSignInMsg(x)
+	case *Game2048Msg:
+		j.processGame2048Msg(x)
+	default:
+	}
+	if t, ok := msg.(traced); ok {
+		setStatus(j.db, t.trace().RequestId, msg, define.StatusProcessed)
+	}
 }
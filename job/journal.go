@@ -0,0 +1,89 @@
+package job
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/coschain/contentos-go/prototype"
+	"github.com/coschain/contentos-go/rpc/pb"
+	"proxy/define"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	journalExpire = 24 * 3600 // seconds
+	// a transaction not seen this long after its expiration is taken as dropped
+	journalMargin = 5 * time.Second
+)
+
+/**
+ * 交易日志
+ *   分区易主后 processing 列表中的消息会被重新执行，执行前一次广播过的交易不能再发一次。
+ *   广播前按 请求:操作:签名账号:序号 记下交易 hash 和过期时间，重放时先到链上查这笔交易：
+ *   已上链的直接视为成功，未过期的等到过期后再判断。
+ */
+func (j *Job) begin(requestId string) {
+	j.request = requestId
+	j.sent = make(map[string]int)
+}
+
+func (j *Job) end() {
+	j.request = ""
+	j.sent = nil
+}
+
+// journalKey is empty for operations outside a traced request.
+func (j *Job) journalKey(opType, signer string) string {
+	if j.request == "" {
+		return ""
+	}
+	step := opType + ":" + signer
+	n := j.sent[step]
+	j.sent[step] = n + 1
+	return fmt.Sprintf("%v%v:%v:%v", define.TxJournalPrefix, j.request, step, n)
+}
+
+// journal records a transaction before it is broadcast.
+func (j *Job) journal(key string, hash []byte, signTx *prototype.SignedTransaction) error {
+	value := fmt.Sprintf("%x:%v", hash, signTx.Trx.Expiration.UtcSeconds)
+	return j.db.SETEX(key, value, journalExpire)
+}
+
+// replayed reports whether the transaction journaled under key made it to
+// chain, waiting for it to expire when it may still be pending.
+func (j *Job) replayed(key string) (bool, error) {
+	data, err := j.db.GETBytes(key)
+	if err != nil || data == nil {
+		return false, err
+	}
+	parts := strings.Split(string(data), ":")
+	if len(parts) != 2 {
+		return false, fmt.Errorf("journal %v invalid:%s", key, data)
+	}
+	hash, err := hex.DecodeString(parts[0])
+	if err != nil {
+		return false, err
+	}
+	expiration, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return false, err
+	}
+
+	for {
+		c := j.rpcPool.GetClient()
+		resp, err := c.GetTrxInfoById(&grpcpb.GetTrxInfoByIdRequest{TrxId: &prototype.Sha256{Hash: hash}})
+		if err != nil {
+			c.SetAlive(false)
+			return false, err
+		}
+		if info := resp.GetInfo(); info.GetTrxWrap() != nil {
+			return info.GetTrxWrap().GetInvoice().GetStatus() == 200, nil
+		}
+		wait := time.Until(time.Unix(expiration, 0).Add(journalMargin))
+		if wait <= 0 {
+			return false, nil
+		}
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,44 @@
+package job
+
+import (
+	"encoding/hex"
+	"errors"
+	"proxy/config"
+	"proxy/define"
+	"proxy/utils"
+	"strconv"
+	"strings"
+)
+
+// newUserKey makes the key pair of a new account, derived from the master
+// seed when one is configured so it can be regenerated after losing redis.
+// derived tells which of the two it is.
+func newUserKey(id string, app *config.App) (pub, priv string, derived bool, err error) {
+	if config.GetConfig().KeySeed == "" {
+		pub, priv, err = utils.GenerateNewKey()
+		return pub, priv, false, err
+	}
+	pub, priv, err = DerivedKey(id, app)
+	return pub, priv, true, err
+}
+
+/**
+ * 由主种子派生账号密钥
+ *	params:
+ *		id   string       redis 中的账号 key，即 I<app name><user id>
+ *		app  *config.App  账号所属 app
+ *	return:
+ *		string  公钥
+ *		string  私钥
+ */
+func DerivedKey(id string, app *config.App) (string, string, error) {
+	seed, err := hex.DecodeString(config.GetConfig().KeySeed)
+	if err != nil || len(seed) == 0 {
+		return "", "", errors.New("key seed not configured")
+	}
+	userId, err := strconv.ParseUint(strings.TrimPrefix(id, define.IdPrefix+app.Name), 10, 64)
+	if err != nil {
+		return "", "", err
+	}
+	return utils.DeriveKey(seed, app.Type, userId)
+}
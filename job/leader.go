@@ -0,0 +1,86 @@
+package job
+
+import (
+	"fmt"
+	"proxy/database"
+	"sync"
+	"time"
+)
+
+/**
+ * 多实例部署时的主节点选举
+ *   通过 redis 租约保证单例任务（奖励扫描等）只在一个实例上运行
+ */
+type Leader struct {
+	db     *database.DB
+	key    string
+	id     string
+	ttl    time.Duration
+	mutex  sync.RWMutex
+	leader bool
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func NewLeader(db *database.DB, key, id string, ttl time.Duration) *Leader {
+	return &Leader{db: db, key: key, id: id, ttl: ttl, stop: make(chan struct{})}
+}
+
+func (l *Leader) Start() {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		l.campaign()
+		select {
+		case <-ticker.C:
+		case <-l.stop:
+			return
+		}
+	}
+}
+
+func (l *Leader) campaign() {
+	var ok bool
+	var err error
+	if l.IsLeader() {
+		ok, err = l.db.RenewLease(l.key, l.id, l.ttl)
+	} else {
+		ok, err = l.db.AcquireLease(l.key, l.id, l.ttl)
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("leader lease key:%v id:%v error:%v", l.key, l.id, err))
+		ok = false
+	}
+	if ok != l.IsLeader() {
+		log.Info(fmt.Sprintf("leader key:%v id:%v leader:%v", l.key, l.id, ok))
+	}
+	l.setLeader(ok)
+}
+
+func (l *Leader) IsLeader() bool {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	return l.leader
+}
+
+// Lease returns the lease key and the token writes of the leader are fenced with.
+func (l *Leader) Lease() (string, string) {
+	return l.key, l.id
+}
+
+func (l *Leader) setLeader(leader bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.leader = leader
+}
+
+// Resign stops campaigning and hands the lease over to another instance.
+func (l *Leader) Resign() {
+	l.once.Do(func() { close(l.stop) })
+	if l.IsLeader() {
+		l.setLeader(false)
+		if err := l.db.ReleaseLease(l.key, l.id); err != nil {
+			log.Error(fmt.Sprintf("release lease key:%v id:%v error:%v", l.key, l.id, err))
+		}
+	}
+}
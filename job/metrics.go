@@ -0,0 +1,66 @@
+package job
+
+import (
+	"sync"
+	"time"
+)
+
+var ageBounds = []struct {
+	bound time.Duration
+	label string
+}{
+	{time.Second, "1s"},
+	{10 * time.Second, "10s"},
+	{time.Minute, "1m"},
+	{10 * time.Minute, "10m"},
+	{time.Hour, "1h"},
+}
+
+type ageHistogram struct {
+	counts  []uint64 // one per bound plus one for older messages
+	expired uint64
+}
+
+var (
+	ageMutex sync.Mutex
+	ages     = map[string]*ageHistogram{}
+)
+
+// observeAge records how long a message waited before a worker picked it up.
+func observeAge(msgType string, age time.Duration, expired bool) {
+	ageMutex.Lock()
+	defer ageMutex.Unlock()
+
+	h := ages[msgType]
+	if h == nil {
+		h = &ageHistogram{counts: make([]uint64, len(ageBounds)+1)}
+		ages[msgType] = h
+	}
+	i := 0
+	for i < len(ageBounds) && age >= ageBounds[i].bound {
+		i++
+	}
+	h.counts[i]++
+	if expired {
+		h.expired++
+	}
+}
+
+// AgeHistogram returns, per message type, how many messages were picked up
+// within each age bound and how many of them had expired.
+func AgeHistogram() map[string]map[string]uint64 {
+	ageMutex.Lock()
+	defer ageMutex.Unlock()
+
+	res := make(map[string]map[string]uint64)
+	for msgType, h := range ages {
+		m := make(map[string]uint64)
+		for i, b := range ageBounds {
+			m["<"+b.label] = h.counts[i]
+		}
+		m[">="+ageBounds[len(ageBounds)-1].label] = h.counts[len(ageBounds)]
+		m["expired"] = h.expired
+		res[msgType] = m
+	}
+	return res
+}
@@ -0,0 +1,243 @@
+package job
+
+import (
+	"fmt"
+	"proxy/database"
+	"proxy/define"
+	"sync"
+	"time"
+)
+
+/**
+ * 分布式消息消费
+ *   每个 redis 分区同一时刻只被一个实例持有（租约），分区内的消息按入队顺序交给本地调度器，
+ *   从而保证同一用户的消息在整个集群内按顺序执行。
+ *   各实例按在线实例数均分分区，实例上下线时自动重新分配。
+ */
+// messages of one partition handed to the scheduler and not done yet
+const partitionInflight = 100
+
+type PartitionConsumer struct {
+	db        *database.DB
+	scheduler *Scheduler
+	id        string
+	count     int
+	ttl       time.Duration
+	mutex     sync.Mutex
+	owned     map[int]chan struct{}
+	// given back or stopped, the lease is renewed until the in-flight messages are done
+	draining map[int]bool
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+	wg       sync.WaitGroup
+}
+
+func NewPartitionConsumer(db *database.DB, s *Scheduler, id string, partitionCount int, ttl time.Duration) *PartitionConsumer {
+	return &PartitionConsumer{
+		db:        db,
+		scheduler: s,
+		id:        id,
+		count:     partitionCount,
+		ttl:       ttl,
+		owned:     make(map[int]chan struct{}),
+		draining:  make(map[int]bool),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start rebalances until Stop, and keeps renewing draining partitions after
+// Stop until they are released.
+func (p *PartitionConsumer) Start() {
+	ticker := time.NewTicker(p.ttl / 3)
+	defer ticker.Stop()
+	for {
+		p.rebalance()
+		select {
+		case <-ticker.C:
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// Stop releases every partition after its in-flight messages are done.
+func (p *PartitionConsumer) Stop() {
+	p.once.Do(func() {
+		close(p.stop)
+		p.mutex.Lock()
+		for part := range p.owned {
+			p.drain(part)
+		}
+		p.mutex.Unlock()
+		go func() {
+			p.wg.Wait()
+			close(p.done)
+		}()
+	})
+	<-p.done
+}
+
+// drain stops taking messages from part, the caller holds the mutex.
+func (p *PartitionConsumer) drain(part int) {
+	close(p.owned[part])
+	delete(p.owned, part)
+	p.draining[part] = true
+}
+
+func (p *PartitionConsumer) rebalance() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// a draining partition must not be taken over while its messages run
+	for part := range p.draining {
+		if ok, err := p.db.RenewLease(partitionOwner(part), p.id, p.ttl); err != nil || !ok {
+			log.Error(fmt.Sprintf("lost draining partition:%v error:%v", part, err))
+		}
+	}
+
+	select {
+	case <-p.stop:
+		return
+	default:
+	}
+
+	now := time.Now()
+	if err := p.db.ZADD(define.Instances, now.Unix(), p.id); err != nil {
+		log.Error(fmt.Sprintf("register instance:%v error:%v", p.id, err))
+		return
+	}
+	if err := p.db.ZREMRANGEBYSCORE(define.Instances, "-inf", now.Add(-p.ttl).Unix()); err != nil {
+		log.Error(fmt.Sprintf("prune instances error:%v", err))
+	}
+	live, err := p.db.ZCARD(define.Instances)
+	if err != nil || live == 0 {
+		live = 1
+	}
+	share := (p.count + live - 1) / live
+
+	// keep what we hold, a lost one is not claimed again until its messages are done
+	for part := range p.owned {
+		ok, err := p.db.RenewLease(partitionOwner(part), p.id, p.ttl)
+		if err != nil || !ok {
+			log.Error(fmt.Sprintf("lost partition:%v error:%v", part, err))
+			p.drain(part)
+		}
+	}
+
+	// give back the surplus so new instances get their share
+	for part := range p.owned {
+		if len(p.owned) <= share {
+			break
+		}
+		p.drain(part)
+	}
+
+	// claim free partitions up to our share, one still draining is not taken again yet
+	for part := 0; part < p.count && len(p.owned) < share; part++ {
+		if _, ok := p.owned[part]; ok || p.draining[part] {
+			continue
+		}
+		ok, err := p.db.AcquireLease(partitionOwner(part), p.id, p.ttl)
+		if err != nil || !ok {
+			continue
+		}
+		stop := make(chan struct{})
+		p.owned[part] = stop
+		p.wg.Add(1)
+		go p.consume(part, stop)
+	}
+}
+
+func (p *PartitionConsumer) consume(part int, stop chan struct{}) {
+	defer p.wg.Done()
+
+	// the lease is only given up once everything taken from the partition is done
+	inflight := &sync.WaitGroup{}
+	slots := make(chan struct{}, partitionInflight)
+	defer func() {
+		inflight.Wait()
+		p.mutex.Lock()
+		delete(p.draining, part)
+		p.mutex.Unlock()
+		if err := p.db.ReleaseLease(partitionOwner(part), p.id); err != nil {
+			log.Error(fmt.Sprintf("release partition:%v error:%v", part, err))
+		}
+	}()
+
+	queue := partitionQueue(part)
+	processing := partitionProcessing(part)
+	log.Info(fmt.Sprintf("instance:%v consume partition:%v", p.id, part))
+
+	// messages left in flight by a previous owner are redone first, oldest at the tail
+	pending, err := p.db.LRANGE(processing, 0, -1)
+	if err != nil {
+		log.Error(fmt.Sprintf("LRANGE %v error:%v", processing, err))
+		return
+	}
+	for i := len(pending) - 1; i >= 0; i-- {
+		if !p.take(slots, stop) {
+			return
+		}
+		p.handle(inflight, slots, processing, pending[i])
+	}
+
+	for {
+		if !p.take(slots, stop) {
+			return
+		}
+		data, err := p.db.BRPOPLPUSH(queue, processing, 1)
+		if err != nil {
+			<-slots
+			log.Error(fmt.Sprintf("BRPOPLPUSH %v error:%v", queue, err))
+			time.Sleep(time.Second)
+			continue
+		}
+		if data == nil {
+			<-slots
+			continue
+		}
+		p.handle(inflight, slots, processing, data)
+	}
+}
+
+// take waits for a free in-flight slot, false once the partition or the
+// consumer is stopped.
+func (p *PartitionConsumer) take(slots chan struct{}, stop chan struct{}) bool {
+	select {
+	case <-stop:
+		return false
+	case <-p.stop:
+		return false
+	default:
+	}
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-stop:
+		return false
+	case <-p.stop:
+		return false
+	}
+}
+
+func (p *PartitionConsumer) handle(inflight *sync.WaitGroup, slots chan struct{}, processing string, data []byte) {
+	ack := func() {
+		if _, err := p.db.LREM(processing, 1, data); err != nil {
+			log.Error(fmt.Sprintf("LREM %v error:%v", processing, err))
+		}
+		<-slots
+	}
+	key, m, err := decodeMsg(data)
+	if err != nil {
+		log.Error(fmt.Sprintf("decode message error:%v data:%s", err, data))
+		ack()
+		return
+	}
+	inflight.Add(1)
+	p.scheduler.Put(key, m, func() {
+		ack()
+		inflight.Done()
+	})
+}
@@ -0,0 +1,280 @@
+package job
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/coschain/contentos-go/rpc/pb"
+	"golang.org/x/time/rate"
+	"proxy/config"
+	"proxy/database"
+	"proxy/define"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const reconcileScanCount = 1000
+
+/**
+ * redis 与链上数据对账
+ *   遍历每个 app 的账号与帖子记录，检查链上是否存在；
+ *   缺失的账号重新创建，缺失的帖子用保存的内容重新发布，修复作为普通消息分发给 worker，单独限速；
+ *   新写入、刚排队修复或正在上链的记录跳过；无法修复的记录写入报告。
+ */
+type Reconciler struct {
+	job        *Job
+	leader     *Leader
+	dispatcher Dispatcher
+	check      *rate.Limiter
+	repair     *rate.Limiter
+	mutex      sync.Mutex
+}
+
+type ReconcileIssue struct {
+	Key    string
+	Reason string
+}
+
+type ReconcileReport struct {
+	Start       int64
+	End         int64
+	Repair      bool             // false only checks
+	Accounts    int              // account records checked
+	Posts       int              // post records checked
+	Missing     []string         // not on chain, left alone because Repair is false
+	Repaired    []string         // repair queued to the workers
+	Skipped     []ReconcileIssue // new, pending or queued for repair, checked on the next sweep
+	Failed      []ReconcileIssue // repair could not be queued, retried on the next sweep
+	Irreparable []ReconcileIssue // the record itself is incomplete
+	Errors      int              // records skipped because of rpc or redis errors
+}
+
+func NewReconciler(j *Job, leader *Leader, d Dispatcher) *Reconciler {
+	conf := config.GetConfig()
+	return &Reconciler{
+		job:        j,
+		leader:     leader,
+		dispatcher: d,
+		check:      rate.NewLimiter(rate.Limit(conf.ReconcileCheckPerSecond), 1),
+		repair:     rate.NewLimiter(rate.Limit(conf.ReconcileRepairPerSecond), 1),
+	}
+}
+
+// Start sweeps with repair every ReconcileInterval seconds on the leader.
+func (r *Reconciler) Start() {
+	interval := time.Duration(config.GetConfig().ReconcileInterval) * time.Second
+	for {
+		time.Sleep(interval)
+		if !r.leader.IsLeader() {
+			continue
+		}
+		if _, err := r.Sweep(true); err != nil {
+			log.Error(fmt.Sprintf("reconcile error:%v", err))
+		}
+	}
+}
+
+// Sweep checks every account and post record once and saves the report.
+func (r *Reconciler) Sweep(repair bool) (*ReconcileReport, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	report := &ReconcileReport{Start: time.Now().Unix(), Repair: repair}
+	for _, app := range config.GetConfig().AppMap {
+		if err := r.scan(define.IdPrefix+app.Name, func(key string) { r.checkAccount(report, app, key) }); err != nil {
+			return nil, err
+		}
+		if err := r.scan(define.PostPrefix+app.Name, func(key string) { r.checkPost(report, app, key) }); err != nil {
+			return nil, err
+		}
+	}
+	report.End = time.Now().Unix()
+	log.Info(fmt.Sprintf("reconcile accounts:%v posts:%v missing:%v repaired:%v skipped:%v failed:%v irreparable:%v errors:%v",
+		report.Accounts, report.Posts, len(report.Missing), len(report.Repaired), len(report.Skipped), len(report.Failed), len(report.Irreparable), report.Errors))
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.job.db.SET(define.ReconcileReport, data); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+// LastReconcileReport returns the report of the latest sweep, nil if there is none.
+func LastReconcileReport(db *database.DB) (*ReconcileReport, error) {
+	data, err := db.GETBytes(define.ReconcileReport)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	report := &ReconcileReport{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// scan calls fn for every key made of prefix and an id.
+func (r *Reconciler) scan(prefix string, fn func(key string)) error {
+	cursor := 0
+	for {
+		next, keys, err := r.job.db.SCAN(cursor, prefix+"*", reconcileScanCount)
+		if err != nil {
+			return err
+		}
+		// app names never overlap in keys, every match belongs to the app
+		for _, key := range keys {
+			r.check.Wait(context.Background())
+			fn(key)
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func (r *Reconciler) checkAccount(report *ReconcileReport, app *config.App, key string) {
+	report.Accounts++
+	account, err := r.job.db.HGETALL(key)
+	if err != nil {
+		report.Errors++
+		return
+	}
+	name := account[define.Name]
+	if name == "" {
+		report.Irreparable = append(report.Irreparable, ReconcileIssue{Key: key, Reason: "no account name"})
+		return
+	}
+	if recent(account) {
+		report.Skipped = append(report.Skipped, ReconcileIssue{Key: key, Reason: "recent"})
+		return
+	}
+	info, ok := r.job.getAccountInfo(name)
+	if !ok {
+		report.Errors++
+		return
+	}
+	if info.GetInfo().GetAccountName() != nil {
+		// the broadcast may have failed on our side after the chain took it, old records have no status
+		if status := account[define.AccountStatus]; status == "" || status == define.AccountPending || status == define.AccountFailed {
+			r.job.setAccountStatus(key, define.AccountOnChain)
+		}
+		return
+	}
+	// the worker may be broadcasting it right now
+	if account[define.AccountStatus] == define.AccountPending {
+		report.Skipped = append(report.Skipped, ReconcileIssue{Key: key, Reason: "pending"})
+		return
+	}
+	if !report.Repair {
+		report.Missing = append(report.Missing, key)
+		return
+	}
+
+	r.repair.Wait(context.Background())
+	if err := r.dispatchRepair(app, key, key, &AccountMsg{Trace: Trace{AppStr: app.Name}, Id: key, Name: name}); err != nil {
+		report.Failed = append(report.Failed, ReconcileIssue{Key: key, Reason: err.Error()})
+		return
+	}
+	log.Info(fmt.Sprintf("reconcile queued account:%v name:%v", key, name))
+	report.Repaired = append(report.Repaired, key)
+}
+
+func (r *Reconciler) checkPost(report *ReconcileReport, app *config.App, key string) {
+	report.Posts++
+	post, err := r.job.db.HGETALL(key)
+	if err != nil {
+		report.Errors++
+		return
+	}
+	uuid, err := strconv.ParseUint(post[define.UUID], 10, 64)
+	if err != nil {
+		report.Irreparable = append(report.Irreparable, ReconcileIssue{Key: key, Reason: "no uuid"})
+		return
+	}
+	if recent(post) {
+		report.Skipped = append(report.Skipped, ReconcileIssue{Key: key, Reason: "recent"})
+		return
+	}
+
+	c := r.job.rpcPool.GetClient()
+	resp, err := c.GetPostInfoById(&grpcpb.GetPostInfoByIdRequest{PostId: uuid})
+	if err != nil {
+		log.Error(fmt.Sprintf("rpc GetPostInfoById error:%v post:%v", err, key))
+		c.SetAlive(false)
+		report.Errors++
+		return
+	}
+	if resp.GetPostInfo().GetPostId() == uuid {
+		return
+	}
+
+	owner := post[define.Owner]
+	_, hasTitle := post[define.Title]
+	switch {
+	case owner == "":
+		report.Irreparable = append(report.Irreparable, ReconcileIssue{Key: key, Reason: "no owner"})
+		return
+	case !hasTitle:
+		// posts recorded before their content was kept
+		report.Irreparable = append(report.Irreparable, ReconcileIssue{Key: key, Reason: "content not recorded"})
+		return
+	}
+	account, err := r.job.db.HGETALL(owner)
+	if err != nil {
+		report.Errors++
+		return
+	}
+	if account[define.Name] == "" {
+		report.Irreparable = append(report.Irreparable, ReconcileIssue{Key: key, Reason: "owner account not recorded"})
+		return
+	}
+	if account[define.AccountStatus] == define.AccountPending {
+		report.Skipped = append(report.Skipped, ReconcileIssue{Key: key, Reason: "owner pending"})
+		return
+	}
+	if !report.Repair {
+		report.Missing = append(report.Missing, key)
+		return
+	}
+
+	r.repair.Wait(context.Background())
+	if err := r.dispatchRepair(app, owner, key, &RepairPostMsg{Trace: Trace{AppStr: app.Name}, PostId: key}); err != nil {
+		report.Failed = append(report.Failed, ReconcileIssue{Key: key, Reason: err.Error()})
+		return
+	}
+	log.Info(fmt.Sprintf("reconcile queued post:%v uuid:%v", key, uuid))
+	report.Repaired = append(report.Repaired, key)
+}
+
+// dispatchRepair queues a repair in the partition of the owning user and
+// marks the record so later sweeps leave it to the worker.
+func (r *Reconciler) dispatchRepair(app *config.App, owner, key string, m interface{}) error {
+	if r.dispatcher == nil {
+		return fmt.Errorf("no dispatcher to queue the repair")
+	}
+	userId, err := strconv.ParseUint(strings.TrimPrefix(owner, define.IdPrefix+app.Name), 10, 64)
+	if err != nil {
+		return fmt.Errorf("owner id invalid:%v", owner)
+	}
+	if err := r.job.db.HSET(key, define.RepairTime, time.Now().Unix()); err != nil {
+		return err
+	}
+	return r.dispatcher.Dispatch(userId, m)
+}
+
+// recent reports whether a record was written or queued for repair within
+// the grace time, its message may not have been processed yet.
+func recent(record map[string]string) bool {
+	since := time.Now().Unix() - int64(config.GetConfig().ReconcileGrace)
+	for _, field := range []string{define.Created, define.RepairTime} {
+		if t, err := strconv.ParseInt(record[field], 10, 64); err == nil && t > since {
+			return true
+		}
+	}
+	return false
+}
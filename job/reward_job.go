@@ -12,20 +12,27 @@ import (
 
 type RewardJob struct {
 	//queue chan interface{}
-	db        *database.DB
-	rpcClient *rpc.Client
+	db      *database.DB
+	rpcPool *rpc.RpcPool
+	leader  *Leader
 }
 
-func NewRewardJob(db *database.DB, pool *rpc.RpcPool) *RewardJob {
-	job := &RewardJob{db: db, rpcClient: pool.GetClient()}
+func NewRewardJob(db *database.DB, pool *rpc.RpcPool, leader *Leader) *RewardJob {
+	job := &RewardJob{db: db, rpcPool: pool, leader: leader}
 	return job
 }
 
 func (j *RewardJob) Start() {
 	duration := time.Second
-	conf := config.GetConfig()
 	for {
 		time.Sleep(duration)
+		conf := config.GetConfig()
+
+		// only the leader instance scans rewards, otherwise they are credited twice
+		if !j.leader.IsLeader() {
+			duration = time.Second
+			continue
+		}
 
 		height, err := j.getBlockHeight()
 		if err != nil {
@@ -33,10 +40,11 @@ func (j *RewardJob) Start() {
 		}
 
 		req := &grpcpb.NonParamsRequest{}
-		resp, err := j.rpcClient.GetStatisticsInfo(req)
+		c := j.rpcPool.GetClient()
+		resp, err := c.GetStatisticsInfo(req)
 		if err != nil {
 			log.Error(fmt.Sprintf("rpc GetStatisticsInfo error:%v", err))
-			j.rpcClient.SetAlive(false)
+			c.SetAlive(false)
 			continue
 		}
 
@@ -49,7 +57,16 @@ func (j *RewardJob) Start() {
 
 		if height > irreversibleHeight {
 			log.Error(fmt.Sprintf("height:%v > irreversibleHeight:%v chain may be cleaned", height, irreversibleHeight))
+			// leadership may be gone after the rpc calls
+			if !j.leader.IsLeader() {
+				continue
+			}
 			height = 0
+			chainReset(j.db)
+			// the ledger starts over with the chain
+			if err := j.db.DEL(define.RewardLedgerStart); err != nil {
+				log.Error(fmt.Sprintf("DEL %v error:%v", define.RewardLedgerStart, err))
+			}
 		}
 
 		if irreversibleHeight-height > 1 {
@@ -59,8 +76,8 @@ func (j *RewardJob) Start() {
 		}
 
 		height++
-		if j.queryReward(height) {
-			j.setBlockHeight(height)
+		if !j.queryReward(height) {
+			duration = time.Second
 		}
 	}
 }
@@ -73,45 +90,55 @@ func (j *RewardJob) getBlockHeight() (uint64, error) {
 	return blockHeight, err
 }
 
-func (j *RewardJob) setBlockHeight(blockHeight uint64) {
-	if err := j.db.SET(define.BlockHeight, blockHeight); err != nil {
-		log.Error(fmt.Sprintf("setBlockHeight error:%v height:%v", err, blockHeight))
-	}
-}
-
 func (j *RewardJob) queryReward(height uint64) bool {
 	req := &grpcpb.GetBlockCashoutRequest{
 		BlockHeight: height,
 	}
 
-	resp, err := j.rpcClient.GetReward(req)
+	c := j.rpcPool.GetClient()
+	resp, err := c.GetReward(req)
 	if err != nil {
 		log.Error(fmt.Sprintf("rpc GetReward error:%v", err))
-		j.rpcClient.SetAlive(false)
+		c.SetAlive(false)
 		return false
 	}
 
-	if len(resp.CashoutList) == 0 {
-		return true
-	}
-
+	rewards := make(map[string]uint64)
 	for _, cash := range resp.CashoutList {
 		id, err := j.db.GETId(cash.AccountName.Value)
 		if err != nil {
 			log.Error(fmt.Sprintf("GETId name:%v error:%v", cash.AccountName.Value, err))
-			continue
+			return false
 		}
 		if id == "" {
 			//log.Warn(fmt.Sprintf("GETId name:%v empty",cash.AccountName.Value))
 			continue
 		}
+		rewards[id] += cash.Reward.Value
+	}
 
-		if err := j.db.AddReward(id, define.Reward, cash.Reward.Value); err != nil {
-			log.Error(fmt.Sprintf("AddReward error:%v id:%v", err, id))
-			continue
-		} else {
-			log.Info(fmt.Sprintf("AddReward ok id:%v reward:%v", id, cash.Reward.Value))
-		}
+	// rewards, ledger and height are written together, a failed block is retried as a whole;
+	// the write is fenced with the lease so an instance that lost it mid block credits nothing
+	leaseKey, owner := j.leader.Lease()
+	ok, err := j.db.CreditBlock(define.BlockHeight, rewardLedgerKey(height), define.RewardLedgerStart, define.RewardPause, leaseKey, owner, height, define.Reward, rewards)
+	if err == database.ErrLeaseLost {
+		log.Info(fmt.Sprintf("lost leadership before crediting height:%v", height))
+		return false
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("CreditBlock error:%v height:%v", err, height))
+		return false
+	}
+	if !ok {
+		log.Info(fmt.Sprintf("rewards paused at height:%v", height))
+		return false
+	}
+	for id, reward := range rewards {
+		log.Info(fmt.Sprintf("AddReward ok id:%v reward:%v height:%v", id, reward, height))
 	}
 	return true
 }
+
+func rewardLedgerKey(height uint64) string {
+	return fmt.Sprintf("%v%v", define.RewardLedgerPrefix, height)
+}
@@ -0,0 +1,265 @@
+package job
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/coschain/contentos-go/rpc/pb"
+	"proxy/database"
+	"proxy/define"
+	"proxy/rpc"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// what the chain cashouts of a range are compared with
+const (
+	// the reward ledger of every block in the range
+	BasisLedger = "ledger"
+	// the cumulative vest field, the range covers every block rewarded so far
+	BasisVest = "vest"
+	// neither is comparable, the diff is only a report
+	BasisPartial = "partial"
+)
+
+/**
+ * 奖励重算结果
+ *   Expected 为区间内链上 cashout 的合计
+ *   Recorded 为对比基准的值：ledger 为区间内账本合计，vest 为 vest 字段的累计值
+ *   Delta    为需要补发(>0)或扣回(<0)的数量
+ */
+type RewardDiff struct {
+	Id       string
+	Name     string
+	Expected uint64
+	Recorded uint64
+	Delta    int64
+	// per block expected value of the ledger entries that differ
+	ledger map[uint64]uint64
+}
+
+/**
+ * 一次重算
+ *   Applicable 为 false 时 Reason 说明原因，此时的差异不能写回
+ */
+type RewardScan struct {
+	From       uint64
+	To         uint64
+	Basis      string
+	Applicable bool
+	Reason     string
+	Diffs      []*RewardDiff
+}
+
+type rewardAudit struct {
+	Id       string
+	Name     string
+	From     uint64
+	To       uint64
+	Basis    string
+	Expected uint64
+	Recorded uint64
+	Delta    int64
+	Time     int64
+}
+
+type RewardRecompute struct {
+	db      *database.DB
+	rpcPool *rpc.RpcPool
+}
+
+func NewRewardRecompute(db *database.DB, pool *rpc.RpcPool) *RewardRecompute {
+	return &RewardRecompute{db: db, rpcPool: pool}
+}
+
+// Pause stops the reward job from crediting blocks until Resume, so the
+// stored height stays put during a full recomputation.
+func (r *RewardRecompute) Pause(owner string, ttl time.Duration) error {
+	ok, err := r.db.AcquireLease(define.RewardPause, owner, ttl)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("rewards are paused by another recomputation")
+	}
+	return nil
+}
+
+func (r *RewardRecompute) Resume(owner string) error {
+	return r.db.ReleaseLease(define.RewardPause, owner)
+}
+
+/**
+ * 重新扫描 [from, to] 的 GetBlockCashout 并与 redis 对比
+ *   账本覆盖整个区间时逐块对比账本，可以写回；
+ *   区间从 1 开始并到达已扫描高度、且奖励扫描已暂停时对比 vest 累计值，可以写回；
+ *   其他情况只报告差异，不能写回
+ */
+func (r *RewardRecompute) Scan(from, to uint64) (*RewardScan, error) {
+	if from == 0 || from > to {
+		return nil, errors.New("invalid block range")
+	}
+
+	c := r.rpcPool.GetClient()
+	stat, err := c.GetStatisticsInfo(&grpcpb.NonParamsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	if to > stat.State.LastIrreversibleBlockNumber {
+		return nil, fmt.Errorf("block %v is not irreversible yet, last irreversible:%v", to, stat.State.LastIrreversibleBlockNumber)
+	}
+
+	height, err := r.db.GETUint64(define.BlockHeight)
+	if err != nil {
+		return nil, err
+	}
+	start, err := r.db.GETUint64(define.RewardLedgerStart)
+	if err != nil {
+		return nil, err
+	}
+	paused, err := r.db.EXISTS(define.RewardPause)
+	if err != nil {
+		return nil, err
+	}
+
+	scan := &RewardScan{From: from, To: to}
+	switch {
+	case to > height:
+		scan.Basis = BasisPartial
+		scan.Reason = fmt.Sprintf("block %v is not credited yet, credited up to %v", to, height)
+	case start > 0 && from >= start:
+		scan.Basis = BasisLedger
+		scan.Applicable = true
+	case from == 1 && to == height && paused:
+		scan.Basis = BasisVest
+		scan.Applicable = true
+	case from == 1 && to == height:
+		scan.Basis = BasisPartial
+		scan.Reason = "rewards are not paused, the credited height may move during the scan"
+	default:
+		scan.Basis = BasisPartial
+		scan.Reason = fmt.Sprintf("the ledger starts at %v and the range does not cover 1-%v", start, height)
+	}
+
+	// per id and block, what the chain paid and what the ledger holds
+	expected := make(map[string]map[uint64]uint64)
+	recorded := make(map[string]map[uint64]uint64)
+	names := make(map[string]string)
+	add := func(m map[string]map[uint64]uint64, id string, height, reward uint64) {
+		if m[id] == nil {
+			m[id] = make(map[uint64]uint64)
+		}
+		m[id][height] += reward
+	}
+	for h := from; h <= to; h++ {
+		resp, err := c.GetReward(&grpcpb.GetBlockCashoutRequest{BlockHeight: h})
+		if err != nil {
+			return nil, fmt.Errorf("rpc GetReward height:%v error:%v", h, err)
+		}
+		for _, cash := range resp.CashoutList {
+			name := cash.AccountName.Value
+			id, err := r.db.GETId(name)
+			if err != nil {
+				return nil, err
+			}
+			if id == "" {
+				continue
+			}
+			names[id] = name
+			add(expected, id, h, cash.Reward.Value)
+		}
+		if start == 0 || h < start {
+			continue
+		}
+		ledger, err := r.db.HGETALL(rewardLedgerKey(h))
+		if err != nil {
+			return nil, err
+		}
+		for id, v := range ledger {
+			reward, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("ledger height:%v id:%v value:%v invalid", h, id, v)
+			}
+			add(recorded, id, h, reward)
+		}
+	}
+
+	// the vest basis must not race the reward job
+	if scan.Basis == BasisVest {
+		now, err := r.db.GETUint64(define.BlockHeight)
+		if err != nil {
+			return nil, err
+		}
+		if now != height {
+			return nil, fmt.Errorf("credited height moved from %v to %v during the scan", height, now)
+		}
+	}
+
+	ids := make(map[string]bool)
+	for id := range expected {
+		ids[id] = true
+	}
+	for id := range recorded {
+		ids[id] = true
+	}
+	for id := range ids {
+		d := &RewardDiff{Id: id, Name: names[id], ledger: make(map[uint64]uint64)}
+		var ledgerTotal uint64
+		for h, reward := range expected[id] {
+			d.Expected += reward
+			// blocks before the ledger started have no entries to correct
+			if start > 0 && h >= start && recorded[id][h] != reward {
+				d.ledger[h] = reward
+			}
+		}
+		for h, reward := range recorded[id] {
+			ledgerTotal += reward
+			if _, ok := expected[id][h]; !ok {
+				d.ledger[h] = 0
+			}
+		}
+		if scan.Basis == BasisLedger {
+			d.Recorded = ledgerTotal
+		} else {
+			if d.Recorded, err = r.db.HGETUint64(id, define.Reward); err != nil {
+				return nil, err
+			}
+		}
+		d.Delta = int64(d.Expected) - int64(d.Recorded)
+		if d.Delta == 0 && len(d.ledger) == 0 {
+			continue
+		}
+		scan.Diffs = append(scan.Diffs, d)
+	}
+	sort.Slice(scan.Diffs, func(i, k int) bool { return scan.Diffs[i].Id < scan.Diffs[k].Id })
+	return scan, nil
+}
+
+// Apply corrects the vest field and the ledger by each diff and records an
+// audit entry for every correction. A scan that is not applicable is refused.
+func (r *RewardRecompute) Apply(scan *RewardScan) error {
+	if !scan.Applicable {
+		return fmt.Errorf("%v diff can not be applied: %v", scan.Basis, scan.Reason)
+	}
+	for _, d := range scan.Diffs {
+		audit := &rewardAudit{
+			Id: d.Id, Name: d.Name, From: scan.From, To: scan.To, Basis: scan.Basis,
+			Expected: d.Expected, Recorded: d.Recorded, Delta: d.Delta,
+			Time: time.Now().Unix(),
+		}
+		data, err := json.Marshal(audit)
+		if err != nil {
+			return err
+		}
+		ledger := make(map[string]uint64, len(d.ledger))
+		for h, reward := range d.ledger {
+			ledger[rewardLedgerKey(h)] = reward
+		}
+		if err := r.db.CorrectReward(d.Id, define.Reward, d.Delta, ledger, define.RewardAudit, data); err != nil {
+			return fmt.Errorf("CorrectReward id:%v error:%v", d.Id, err)
+		}
+		log.Info(fmt.Sprintf("reward corrected id:%v name:%v delta:%v basis:%v range:%v-%v", d.Id, d.Name, d.Delta, scan.Basis, scan.From, scan.To))
+	}
+	return nil
+}
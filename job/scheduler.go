@@ -0,0 +1,342 @@
+package job
+
+import (
+	"fmt"
+	"proxy/config"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+/**
+ * 消息调度
+ *   每个用户一个有序子队列，所有 worker 共享，任意空闲 worker 都可以取走任意就绪用户的下一条消息。
+ *   同一用户同一时刻最多只有一条消息在处理，保证用户内顺序；
+ *   一个慢用户只占用一个 worker，不再阻塞同一分桶里的其他用户。
+ *   worker 数量在 min 与 len(jobs) 之间随积压量伸缩，路由与 worker 数量无关，伸缩不影响用户内顺序。
+ *   就绪用户按队首消息的优先级进入不同通道，高优先级通道先被取走；
+ *   低优先级通道连续被跳过 starveLimit 次后必定被取一次，避免饿死。
+ */
+type Scheduler struct {
+	mutex    sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	users    map[uint64]*userQueue
+	ready    [laneCount][]*userQueue
+	skipped  [laneCount]int
+	pending  int
+	capacity int
+	jobs     []*Job
+	spare    []*Job // workers not running
+	busy     int    // workers processing a message
+	retire   int    // running workers asked to exit
+	min      int
+	current  map[int]*userQueue // user being processed, by worker index
+
+	pausedTypes   map[string]bool
+	pausedWorkers map[int]bool
+
+	priorities  map[string]int
+	starveLimit int
+}
+
+const (
+	laneHigh = iota
+	laneNormal
+	laneLow
+	laneCount
+)
+
+var laneNames = map[string]int{
+	"high":   laneHigh,
+	"normal": laneNormal,
+	"low":    laneLow,
+}
+
+// account creation and game settlement are business critical, fake actions are filler
+var defaultPriorities = map[string]int{
+	"AccountMsg":     laneHigh,
+	"Game2048Msg":    laneHigh,
+	"FakeLikeMsg":    laneLow,
+	"FakeCommentMsg": laneLow,
+	"RepairPostMsg":  laneLow,
+}
+
+type task struct {
+	msg  interface{}
+	done func()
+}
+
+type userQueue struct {
+	key     uint64
+	tasks   []*task
+	running bool
+}
+
+// NewScheduler runs between min and len(jobs) workers.
+func NewScheduler(jobs []*Job, min int) *Scheduler {
+	conf := config.GetConfig()
+	s := &Scheduler{
+		users:    make(map[uint64]*userQueue),
+		capacity: size * len(jobs),
+		jobs:     jobs,
+		min:      min,
+		current:  make(map[int]*userQueue),
+
+		pausedTypes:   make(map[string]bool),
+		pausedWorkers: make(map[int]bool),
+
+		priorities:  make(map[string]int),
+		starveLimit: conf.LaneStarveLimit,
+	}
+	for msgType, lane := range defaultPriorities {
+		s.priorities[msgType] = lane
+	}
+	for msgType, name := range conf.MessagePriorities {
+		if lane, ok := laneNames[name]; ok {
+			s.priorities[msgType] = lane
+		} else {
+			log.Error(fmt.Sprintf("unknown priority:%v for message type:%v", name, msgType))
+		}
+	}
+	for i := len(jobs) - 1; i >= 0; i-- {
+		s.spare = append(s.spare, jobs[i])
+	}
+	s.notEmpty = sync.NewCond(&s.mutex)
+	s.notFull = sync.NewCond(&s.mutex)
+	return s
+}
+
+func (s *Scheduler) Start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.grow(s.min)
+}
+
+// Autoscale adds workers while users wait for one and removes idle workers
+// once the backlog is gone. It stops adding workers while the rpc latency
+// exceeds maxLatency, since more concurrency then only loads the chain node.
+func (s *Scheduler) Autoscale(latency func() time.Duration, maxLatency time.Duration) {
+	for {
+		time.Sleep(time.Second)
+		rpcLatency := latency()
+
+		s.mutex.Lock()
+		workers := s.workers()
+		idle := workers - s.busy
+		backlog := s.readyLen()
+		if backlog > idle && workers < len(s.jobs) && rpcLatency < maxLatency {
+			n := backlog - idle
+			if n > len(s.jobs)-workers {
+				n = len(s.jobs) - workers
+			}
+			s.grow(n)
+			log.Info(fmt.Sprintf("scheduler grow workers:%v backlog:%v latency:%v", workers+n, backlog, rpcLatency))
+		} else if backlog == 0 && idle > 1 && workers > s.min {
+			n := idle / 2
+			if n > workers-s.min {
+				n = workers - s.min
+			}
+			s.shrink(n)
+			log.Info(fmt.Sprintf("scheduler shrink workers:%v latency:%v", workers-n, rpcLatency))
+		}
+		s.mutex.Unlock()
+	}
+}
+
+// Workers returns the number of running workers.
+func (s *Scheduler) Workers() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.workers()
+}
+
+func (s *Scheduler) workers() int {
+	return len(s.jobs) - len(s.spare) - s.retire
+}
+
+func (s *Scheduler) grow(n int) {
+	for i := 0; i < n && len(s.spare) > 0; i++ {
+		j := s.spare[len(s.spare)-1]
+		s.spare = s.spare[:len(s.spare)-1]
+		go s.work(j)
+	}
+}
+
+func (s *Scheduler) shrink(n int) {
+	s.retire += n
+	s.notEmpty.Broadcast()
+}
+
+// Put queues m behind the user's earlier messages, blocking while the
+// scheduler is full. done, if not nil, is called once m has been processed.
+func (s *Scheduler) Put(key uint64, m interface{}, done func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for s.pending >= s.capacity {
+		s.notFull.Wait()
+	}
+	s.pending++
+
+	u := s.users[key]
+	if u == nil {
+		u = &userQueue{key: key}
+		s.users[key] = u
+	}
+	u.tasks = append(u.tasks, &task{msg: m, done: done})
+	if !u.running && len(u.tasks) == 1 {
+		s.pushReady(u)
+	}
+}
+
+func (s *Scheduler) lane(m interface{}) int {
+	if lane, ok := s.priorities[msgTypeName(m)]; ok {
+		return lane
+	}
+	return laneNormal
+}
+
+func (s *Scheduler) readyLen() int {
+	n := 0
+	for _, r := range s.ready {
+		n += len(r)
+	}
+	return n
+}
+
+// pushReady queues a user in the lane of its next message.
+func (s *Scheduler) pushReady(u *userQueue) {
+	lane := s.lane(u.tasks[0].msg)
+	s.ready[lane] = append(s.ready[lane], u)
+	if len(s.pausedTypes) > 0 || len(s.pausedWorkers) > 0 {
+		// the one woken up might be paused or unable to take this user
+		s.notEmpty.Broadcast()
+	} else {
+		s.notEmpty.Signal()
+	}
+}
+
+// popReady takes a user from the highest lane with a runnable user, unless a
+// lower lane has been passed over starveLimit times in a row. Users whose
+// next message type is paused are left in place. It returns nil if no user
+// can run.
+func (s *Scheduler) popReady() *userQueue {
+	var first [laneCount]int
+	for lane := range s.ready {
+		first[lane] = -1
+		for i, u := range s.ready[lane] {
+			if !s.pausedTypes[msgTypeName(u.tasks[0].msg)] {
+				first[lane] = i
+				break
+			}
+		}
+	}
+
+	pick := -1
+	for lane := laneCount - 1; lane >= 0; lane-- {
+		if first[lane] >= 0 && s.skipped[lane] >= s.starveLimit {
+			pick = lane
+			break
+		}
+	}
+	if pick < 0 {
+		for lane := 0; lane < laneCount; lane++ {
+			if first[lane] >= 0 {
+				pick = lane
+				break
+			}
+		}
+	}
+	if pick < 0 {
+		return nil
+	}
+	for lane := 0; lane < laneCount; lane++ {
+		if lane == pick {
+			s.skipped[lane] = 0
+		} else if first[lane] >= 0 {
+			s.skipped[lane]++
+		}
+	}
+
+	i := first[pick]
+	u := s.ready[pick][i]
+	copy(s.ready[pick][i:], s.ready[pick][i+1:])
+	s.ready[pick][len(s.ready[pick])-1] = nil
+	s.ready[pick] = s.ready[pick][:len(s.ready[pick])-1]
+	return u
+}
+
+// next returns nil when the calling worker j should exit.
+func (s *Scheduler) next(j *Job) (*userQueue, *task) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for {
+		if s.retire > 0 {
+			s.retire--
+			s.spare = append(s.spare, j)
+			return nil, nil
+		}
+		if !s.pausedWorkers[j.index] {
+			if u := s.popReady(); u != nil {
+				s.busy++
+				u.running = true
+				s.current[j.index] = u
+				return u, u.tasks[0]
+			}
+		}
+		s.notEmpty.Wait()
+	}
+}
+
+func (s *Scheduler) finish(j *Job, u *userQueue) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.current, j.index)
+
+	u.tasks[0] = nil
+	u.tasks = u.tasks[1:]
+	u.running = false
+	s.busy--
+	if len(u.tasks) > 0 {
+		s.pushReady(u)
+	} else {
+		delete(s.users, u.key)
+	}
+	s.pending--
+	s.notFull.Signal()
+}
+
+func (s *Scheduler) work(j *Job) {
+	for {
+		u, t := s.next(j)
+		if u == nil {
+			return
+		}
+		ok := s.run(j, u.key, t)
+		s.finish(j, u)
+		if t.done != nil {
+			t.done()
+		}
+		if !ok {
+			// the message is in the dead letter list, restart the worker from scratch
+			log.Error(fmt.Sprintf("job_%v restarted after panic", j.index))
+			go s.work(j)
+			return
+		}
+	}
+}
+
+// run processes one message, turning a panic into a dead letter.
+func (s *Scheduler) run(j *Job, key uint64, t *task) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			addDeadLetter(j.db, key, t.msg, fmt.Sprint(r), string(debug.Stack()))
+			ok = false
+		}
+	}()
+	j.process(t.msg)
+	return true
+}
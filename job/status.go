@@ -0,0 +1,99 @@
+package job
+
+import (
+	"crypto/rand"
+	"fmt"
+	"proxy/config"
+	"proxy/database"
+	"proxy/define"
+	"time"
+)
+
+const statusExpire = 7 * 24 * 3600 // seconds
+
+// stamp fills in the request id, enqueue time and deadline of a message
+// before it enters the pipeline.
+func stamp(m interface{}) {
+	t, ok := m.(traced)
+	if !ok {
+		return
+	}
+	tr := t.trace()
+	now := time.Now()
+	if tr.RequestId == "" {
+		tr.RequestId = newRequestId()
+	}
+	if tr.EnqueueTime == 0 {
+		tr.EnqueueTime = now.UnixNano()
+	}
+	if tr.Deadline == 0 {
+		if d := messageDeadline(msgTypeName(m)); d > 0 {
+			tr.Deadline = now.Add(d).UnixNano()
+		}
+	}
+}
+
+func messageDeadline(msgType string) time.Duration {
+	conf := config.GetConfig()
+	if d, ok := conf.MessageDeadlines[msgType]; ok {
+		return time.Duration(d) * time.Second
+	}
+	return time.Duration(conf.MessageDeadline) * time.Second
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return fmt.Sprintf("%x", b)
+}
+
+// setStatus records the outcome of a request in redis for later lookup,
+// m fills in the message type and app when it is known.
+func setStatus(db *database.DB, requestId string, m interface{}, status string) {
+	if requestId == "" {
+		return
+	}
+	key := define.StatusPrefix + requestId
+	if err := db.SetStatus(key, statusExpire, statusFields(m, status)...); err != nil {
+		log.Error(fmt.Sprintf("SetStatus request:%v status:%v error:%v", requestId, status, err))
+	}
+}
+
+func statusFields(m interface{}, status string) []interface{} {
+	fields := []interface{}{define.Status, status}
+	if m != nil {
+		fields = append(fields, define.MsgType, msgTypeName(m))
+		if t, ok := m.(traced); ok && t.trace().AppStr != "" {
+			fields = append(fields, define.MsgApp, t.trace().AppStr)
+		}
+	}
+	return fields
+}
+
+// Stamp gives m its request id ahead of dispatch and returns it.
+func Stamp(m interface{}) string {
+	stamp(m)
+	return requestIdOf(m)
+}
+
+/**
+ * 请求状态
+ *   queued 已入队, scheduled 定时中, cancelled 已取消, processed 已处理, expired 已过期, dead 进入死信
+ *   记录保存 7 天
+ */
+type RequestStatus struct {
+	Status string
+	Type   string
+	App    string
+}
+
+// GetStatus returns nil if the request is unknown or its record expired.
+func GetStatus(db *database.DB, requestId string) (*RequestStatus, error) {
+	record, err := db.HGETALL(define.StatusPrefix + requestId)
+	if err != nil || len(record) == 0 {
+		return nil, err
+	}
+	return &RequestStatus{Status: record[define.Status], Type: record[define.MsgType], App: record[define.MsgApp]}, nil
+}
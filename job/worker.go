@@ -8,8 +8,13 @@ import (
 	"proxy/config"
 	"proxy/define"
 	"proxy/utils"
+	"strconv"
+	"time"
 )
 
+// names tried for an account before giving up
+const nameAttempts = 5
+
 /**
  * 创建账号
  */
@@ -77,6 +82,14 @@ type PostMsg struct {
 	Tag     string
 }
 
+/**
+ * 重新发布链上缺失的帖子，内容取自 redis 记录
+ */
+type RepairPostMsg struct {
+	Trace
+	PostId string
+}
+
 type FakeCommentMsg struct {
 	Trace
 	Id      string
@@ -113,8 +126,12 @@ func (j *Job) processGame2048Msg(m *Game2048Msg) {
 		log.Error(fmt.Sprintf("Get winnerName error: wid:%v, lid:%v, gid:%v", m.Wid, m.Lid, m.Gid))
 		return
 	}
+	// a new account's name is only claimed in redis so far, check it on chain
+	var ok bool
 	if winnerName != "" {
 		m.Wname = winnerName
+	} else if m.Wname, ok = j.freeName(m.Wid, m.Wname); !ok {
+		return
 	}
 
 	loserName, err := j.db.HGETString(m.Lid, define.Name)
@@ -124,18 +141,26 @@ func (j *Job) processGame2048Msg(m *Game2048Msg) {
 	}
 	if loserName != "" {
 		m.Lname = loserName
+	} else if m.Lname, ok = j.freeName(m.Lid, m.Lname); !ok {
+		return
+	}
+
+	app := getApp(m.AppStr)
+	if app == nil {
+		return
 	}
+	// funding, rebalancing and the settlement are batched, consecutive operations of one signer share a transaction
+	b := &txBatch{}
 
-	conf := config.GetConfig()
 	// if account not exist in chain, create if firstly
 	winnerAccountInfo, werr := j.getAccountInfo(m.Wname)
 	if werr == false || winnerAccountInfo == nil {
-		if !j.createAccount(m.Wid, m.Wname, m.AppStr) {
+		if !j.addCreateAccount(b, m.Wid, m.Wname, m.AppStr) {
 			log.Error(fmt.Sprintf("repair account:%v name:%v failed", m.Wid, m.Wname))
 			return
 		} else {
 			// transfer to cos
-			if !j.transferTo(&TransferOption{id: m.Wid, name: m.Wname, val: m.Wcos, limit: 0}) {
+			if !j.addTransfer(b, &TransferOption{app: app, id: m.Wid, name: m.Wname, val: m.Wcos, limit: 0}) {
 				return
 			}
 		}
@@ -144,7 +169,7 @@ func (j *Job) processGame2048Msg(m *Game2048Msg) {
 		getWinnerCoin := winnerAccountInfo.GetInfo().GetCoin().GetValue()
 		// 2048 cos > chain cos
 		if getWinnerCoin < (m.Wcos - m.Cos) {
-			if !j.transferTo(&TransferOption{id: m.Wid, name: m.Wname, val: (m.Wcos - m.Cos - getWinnerCoin), limit: 0}) {
+			if !j.addTransfer(b, &TransferOption{app: app, id: m.Wid, name: m.Wname, val: (m.Wcos - m.Cos - getWinnerCoin), limit: 0}) {
 				return
 			}
 
@@ -155,27 +180,24 @@ func (j *Job) processGame2048Msg(m *Game2048Msg) {
 				log.Error(fmt.Sprintf("get private key error:%v account:%v key:%v", err, m.Wid, WprivKeyStr))
 				return
 			}
-			if !j.loserTransferToWinner(&LoserTransferWinnerOption{
+			j.addLoserTransfer(b, &LoserTransferWinnerOption{
 				Lid: m.Wid, Lname: m.Wname, Lprivkey: WprivKeyStr,
-				Wname:  conf.TransferName,
+				Wname:  app.TransferName,
 				Cosnum: getWinnerCoin - m.Wcos + m.Cos,
 				Memo:   ""},
-			) {
-				log.Error(fmt.Sprintf("tarnsfer error, id:%v name:%v, cos:%v", m.Wid, m.Wname, getWinnerCoin))
-				return
-			}
+			)
 		}
 	}
 
 	// loser
 	accountInfo, aerr := j.getAccountInfo(m.Lname)
 	if aerr == false || accountInfo == nil {
-		if !j.createAccount(m.Lid, m.Lname, m.AppStr) {
+		if !j.addCreateAccount(b, m.Lid, m.Lname, m.AppStr) {
 			log.Error(fmt.Sprintf("repair account:%v name:%v failed", m.Lid, m.Lname))
 			return
 		} else {
 			// transfer to cos
-			if !j.transferTo(&TransferOption{id: m.Lid, name: m.Lname, val: m.Lcos, limit: 0}) {
+			if !j.addTransfer(b, &TransferOption{app: app, id: m.Lid, name: m.Lname, val: m.Lcos, limit: 0}) {
 				return
 			}
 		}
@@ -183,7 +205,7 @@ func (j *Job) processGame2048Msg(m *Game2048Msg) {
 		// check coin
 		getCoin := accountInfo.GetInfo().GetCoin().GetValue()
 		if getCoin < (m.Lcos + m.Cos) {
-			if !j.transferTo(&TransferOption{id: m.Lid, name: m.Lname, val: (m.Lcos - getCoin + m.Cos), limit: 0}) {
+			if !j.addTransfer(b, &TransferOption{app: app, id: m.Lid, name: m.Lname, val: (m.Lcos - getCoin + m.Cos), limit: 0}) {
 				return
 			}
 		} else if getCoin > (m.Lcos + m.Cos) {
@@ -194,15 +216,12 @@ func (j *Job) processGame2048Msg(m *Game2048Msg) {
 				log.Error(fmt.Sprintf("get private key error:%v account:%v key:%v", err, m.Lid, LprivKeyStr))
 				return
 			}
-			if !j.loserTransferToWinner(&LoserTransferWinnerOption{
+			j.addLoserTransfer(b, &LoserTransferWinnerOption{
 				Lid: m.Lid, Lname: m.Lname, Lprivkey: LprivKeyStr,
-				Wname:  conf.TransferName,
+				Wname:  app.TransferName,
 				Cosnum: getCoin - m.Lcos + m.Cos,
 				Memo:   ""},
-			) {
-				log.Error(fmt.Sprintf("tarnsfer error, id:%v name:%v, cos:%v", m.Lid, m.Lname, getCoin))
-				return
-			}
+			)
 
 		}
 	}
@@ -214,35 +233,26 @@ func (j *Job) processGame2048Msg(m *Game2048Msg) {
 		return
 	}
 	memo := ""
-	if !j.loserTransferToWinner(
+	j.addLoserTransfer(b,
 		&LoserTransferWinnerOption{
 			Lid: m.Lid, Lname: m.Lname, Lprivkey: privKeyStr,
 			Wname:  m.Wname,
 			Cosnum: m.Cos,
 			Memo:   memo,
 		},
-	) {
+	)
+	if !j.flush(b) {
 		log.Error(fmt.Sprintf("tarnsfer error, loserId:%v, loserName:%v, winnerName:%v, cosNum:%v", m.Lid, m.Lname, m.Wname, m.Cos))
 		return
 	}
 }
 
 func (j *Job) processFakeCommentMsg(m *FakeCommentMsg) {
-	// repair chain's account
-	if !j.accountExistInChain(m.Name) {
-		if !j.createAccount(m.Id, m.Name, m.AppStr) {
-			log.Error(fmt.Sprintf("repair account:%v failed", m.Name))
-			return
-		}
-	}
-
-	if !j.transferTo(
-		&TransferOption{id: m.Id, name: m.Name, val: tfLimit, limit: tfLimit},
-	) {
+	app := getApp(m.AppStr)
+	if app == nil {
 		return
 	}
 
-	conf := config.GetConfig()
 	commentUUID := utils.GenerateUUID(m.Name)
 	randomNum := uint64(rand.Uint32())<<32 + uint64(rand.Uint32())
 	content, err := json.Marshal(m.Content)
@@ -250,28 +260,61 @@ func (j *Job) processFakeCommentMsg(m *FakeCommentMsg) {
 		log.Error(fmt.Sprintf("json encode error: id:%v, name:%v, method:%v, content:%v", m.Id, m.Name, "FakeComment", m.Content))
 	}
 	param := fmt.Sprintf(" [%v,\"%v\",%v,%v] ", commentUUID, m.Name, string(content), randomNum)
-	j.callContract(m.Id, m.Name, "FakeComment", conf.ContractName, conf.ContractCommentMethod, param)
+	j.userContractCall(m.Id, m.Name, app, "FakeComment", app.ContractCommentMethod, param)
 }
 
-func (j *Job) processFakeLikeMsg(m *FakeLikeMsg) {
-	// repair chain's account
-	if !j.accountExistInChain(m.Name) {
-		if !j.createAccount(m.Id, m.Name, m.AppStr) {
-			log.Error(fmt.Sprintf("repair account:%v failed", m.Name))
-			return
+/**
+ * 以用户身份调用合约
+ *   ContractCaller 为 user 时由用户签名：先修复账号并充值，再单独发一笔调用交易；
+ *   为 transfer 时由 app 的转账账号代为调用（参数中带有用户名），不需要充值，
+ *   调用只有一笔交易，创建账号与转账账号相同时修复账号也合并在这笔交易中。
+ *   链上一笔交易只能有一个签名，user 模式无法把充值和调用合并。
+ */
+func (j *Job) userContractCall(id, name string, app *config.App, opName, method, param string) bool {
+	if app.ContractCaller != define.CallerTransfer {
+		if !j.prepareCaller(id, name, app) {
+			return false
 		}
+		return j.callContract(id, name, opName, app, method, param)
 	}
 
-	if !j.transferTo(
-		&TransferOption{id: m.Id, name: m.Name, val: tfLimit, limit: tfLimit},
-	) {
+	b := &txBatch{}
+	if !j.accountExistInChain(name) {
+		if !j.addCreateAccount(b, id, name, app.Name) {
+			log.Error(fmt.Sprintf("repair account:%v name:%v failed", id, name))
+			return false
+		}
+	}
+	b.add(id, app.TransferName, app.TransferPriKey, opName, contractOp(app.TransferName, app, method, param))
+	return j.flush(b)
+}
+
+// prepareCaller repairs the account of a contract caller and tops it up so it
+// can pay for the call. When the creator and the transfer account are the same,
+// both operations go out in one transaction.
+func (j *Job) prepareCaller(id, name string, app *config.App) bool {
+	b := &txBatch{}
+	if !j.accountExistInChain(name) {
+		if !j.addCreateAccount(b, id, name, app.Name) {
+			log.Error(fmt.Sprintf("repair account:%v name:%v failed", id, name))
+			return false
+		}
+	}
+	if !j.addTransfer(b, &TransferOption{app: app, id: id, name: name, val: app.TransferAmount, limit: app.TransferLimit}) {
+		return false
+	}
+	return j.flush(b)
+}
+
+func (j *Job) processFakeLikeMsg(m *FakeLikeMsg) {
+	app := getApp(m.AppStr)
+	if app == nil {
 		return
 	}
 
-	conf := config.GetConfig()
 	randomNum := uint64(rand.Uint32())<<32 + uint64(rand.Uint32())
 	param := fmt.Sprintf(" [\"%v\",%v] ", m.Name, randomNum)
-	j.callContract(m.Id, m.Name, "fakeLike", conf.ContractName, conf.ContractLikeMethod, param)
+	j.userContractCall(m.Id, m.Name, app, "fakeLike", app.ContractLikeMethod, param)
 }
 
 func (j *Job) createPost(pid, id, title, content, tag, app string) bool {
@@ -287,7 +330,15 @@ func (j *Job) createPost(pid, id, title, content, tag, app string) bool {
 		log.Error(fmt.Sprintf("SetPostInfo error:%v post_id:%v", err, pid))
 		return false
 	}
+	// the content is kept so the reconciler can write a lost post again
+	if err := j.db.HMSET(pid, define.Title, title, define.Content, content, define.Tag, tag, define.Created, time.Now().Unix()); err != nil {
+		log.Error(fmt.Sprintf("save post content error:%v post_id:%v", err, pid))
+	}
+	return j.writePost(id, name, uuid, title, content, tag, app)
+}
 
+// writePost puts a recorded post on chain, creating the owner account if needed.
+func (j *Job) writePost(id, name string, uuid uint64, title, content, tag, app string) bool {
 	// if account not exist in chain, create it firstly
 	if !j.accountExistInChain(name) {
 		if !j.createAccount(id, name, app) {
@@ -322,6 +373,26 @@ func (j *Job) createPost(pid, id, title, content, tag, app string) bool {
 	return j.call(id, name, "post", signTx)
 }
 
+func (j *Job) processRepairPostMsg(m *RepairPostMsg) {
+	post, err := j.db.HGETALL(m.PostId)
+	if err != nil {
+		log.Error(fmt.Sprintf("get post error:%v post_id:%v", err, m.PostId))
+		return
+	}
+	uuid, err := strconv.ParseUint(post[define.UUID], 10, 64)
+	if err != nil {
+		log.Error(fmt.Sprintf("post uuid invalid:%v post_id:%v", post[define.UUID], m.PostId))
+		return
+	}
+	owner := post[define.Owner]
+	name, err := j.db.HGETString(owner, define.Name)
+	if err != nil || name == "" {
+		log.Error(fmt.Sprintf("get account name error:%v account:%v name:%v", err, owner, name))
+		return
+	}
+	j.writePost(owner, name, uuid, post[define.Title], post[define.Content], post[define.Tag], m.AppStr)
+}
+
 func (j *Job) processSignInMsg(m *SignInMsg) {
 
 	// check the uInfo
@@ -331,29 +402,20 @@ func (j *Job) processSignInMsg(m *SignInMsg) {
 		return
 	}
 
-	// check unique
-	uniqueKey := m.Id + m.Date
-	if err := j.db.SET(uniqueKey, 1); err != nil {
+	app := getApp(m.AppStr)
+	if app == nil {
 		return
 	}
-	// if account not exist in chain, create it firstly
-	if !j.accountExistInChain(name) {
-		if !j.createAccount(m.Id, name, m.AppStr) {
-			log.Error(fmt.Sprintf("repair account:%v name:%v failed", m.Id, name))
-			return
-		}
-	}
 
-	if !j.transferTo(
-		&TransferOption{id: m.Id, name: name, val: tfLimit, limit: tfLimit},
-	) {
+	// check unique
+	uniqueKey := m.Id + m.Date
+	if err := j.db.SET(uniqueKey, 1); err != nil {
 		return
 	}
 
 	// 上报
-	conf := config.GetConfig()
 	param := fmt.Sprintf(" [\"%v\"] ", name)
-	j.callContract(m.Id, name, "signIn", conf.ContractName, conf.ContractSignInMethod, param)
+	j.userContractCall(m.Id, name, app, "signIn", app.ContractSignInMethod, param)
 }
 
 func (j *Job) processLikeMsg(m *LikeMsg) {
@@ -561,7 +623,66 @@ func (j *Job) processFollowMsg(m *FollowMsg) {
 }
 
 func (j *Job) processAccountMsg(m *AccountMsg) {
-	j.createAccount(m.Id, m.Name, m.AppStr)
+	recorded, err := j.db.HGETString(m.Id, define.Name)
+	if err != nil {
+		log.Error(fmt.Sprintf("get account name error:%v account:%v", err, m.Id))
+		return
+	}
+	name := recorded
+	if recorded == "" {
+		// a new account, the name is only claimed in redis so far
+		var ok bool
+		if name, ok = j.freeName(m.Id, m.Name); !ok {
+			return
+		}
+	} else if j.accountExistInChain(recorded) {
+		// a repair the chain has taken meanwhile
+		j.setAccountStatus(m.Id, define.AccountOnChain)
+		return
+	}
+	j.createAccount(m.Id, name, m.AppStr)
+}
+
+// freeName returns name if the chain does not have it yet, otherwise it
+// claims another one with a new random suffix for id. It gives up when the
+// chain can not be asked, an unchecked name is never used.
+func (j *Job) freeName(id, name string) (string, bool) {
+	for i := 0; i < nameAttempts; i++ {
+		exists, err := j.lookupAccount(name)
+		if err != nil {
+			log.Error(fmt.Sprintf("check name:%v error:%v account:%v", name, err, id))
+			return "", false
+		}
+		if !exists {
+			return name, true
+		}
+		next, ok := j.claimNextName(id, name)
+		if !ok {
+			return "", false
+		}
+		log.Info(fmt.Sprintf("account:%v name:%v taken on chain, renamed to:%v", id, name, next))
+		name = next
+	}
+	log.Error(fmt.Sprintf("no free name for account:%v after %v attempts", id, nameAttempts))
+	return "", false
+}
+
+// claimNextName claims name with a new random suffix in the registry, a
+// suffix claimed by another id meanwhile is replaced by a fresh one.
+func (j *Job) claimNextName(id, name string) (string, bool) {
+	for i := 0; i < nameAttempts; i++ {
+		next := utils.GenerateName(name)
+		claimed, err := j.db.SETNX(define.NameRegistryPrefix+next, id, 0)
+		if err != nil {
+			log.Error(fmt.Sprintf("claim name:%v error:%v account:%v", next, err, id))
+			return "", false
+		}
+		if claimed {
+			return next, true
+		}
+	}
+	log.Error(fmt.Sprintf("no unclaimed name for account:%v after %v attempts", id, nameAttempts))
+	return "", false
 }
 
 func (j *Job) processPostMsg(m *PostMsg) {
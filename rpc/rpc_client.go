@@ -7,6 +7,7 @@ import (
 	"google.golang.org/grpc"
 	"proxy/config"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,39 +15,93 @@ var mutex sync.Mutex
 
 type Client struct {
 	alive     bool
+	conn      *grpc.ClientConn
 	rpcClient grpcpb.ApiServiceClient
 	ip        string
 	timeout   int
+	latency   int64 // moving average of call duration in nanoseconds
 }
 
+// connections replaced by Reset are closed once calls on them are surely over
+const retireDelay = time.Minute
+
 type RpcPool struct {
-	pool []*Client
+	pool    []*Client
+	timeout int
 }
 
 func NewRpcPool(ips []string, rpcTimeout int) *RpcPool {
-	rp := &RpcPool{}
+	rp := &RpcPool{timeout: rpcTimeout}
+	clients, err := rp.connect(ips)
+	if err != nil {
+		panic("can not connect to chain rpc")
+	}
+	rp.pool = clients
+	go rp.checkAlive()
+	return rp
+}
 
+func (r *RpcPool) connect(ips []string) ([]*Client, error) {
+	var clients []*Client
 	for _, ip := range ips {
 		conn, err := dial(ip)
 		if err != nil {
-			panic("can not connect to chain rpc")
+			for _, c := range clients {
+				c.conn.Close()
+			}
+			return nil, err
 		}
 		rpc := grpcpb.NewApiServiceClient(conn)
-		c := &Client{alive: true, rpcClient: rpc, ip: ip, timeout: rpcTimeout}
-		rp.push(c)
+		clients = append(clients, &Client{alive: true, conn: conn, rpcClient: rpc, ip: ip, timeout: r.timeout})
 	}
-	go rp.checkAlive()
-	return rp
+	return clients, nil
 }
 
-func (r *RpcPool) checkAlive() {
+// Reset replaces the pool with connections to ips.
+func (r *RpcPool) Reset(ips []string) error {
+	clients, err := r.connect(ips)
+	if err != nil {
+		return err
+	}
+	mutex.Lock()
+	old := r.pool
+	r.pool = clients
+	mutex.Unlock()
+
+	time.AfterFunc(retireDelay, func() {
+		for _, c := range old {
+			c.conn.Close()
+		}
+	})
+	return nil
+}
+
+func (r *RpcPool) clients() []*Client {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return r.pool
+}
+
+// probeName is any known account, the deployer may be set per app only.
+func probeName() string {
 	conf := config.GetConfig()
-	getAccount := &grpcpb.GetAccountByNameRequest{
-		AccountName: &prototype.AccountName{Value: conf.ContractDeployerName},
+	probe := conf.ContractDeployerName
+	for _, app := range conf.AppMap {
+		if probe != "" {
+			break
+		}
+		probe = app.ContractDeployerName
 	}
+	return probe
+}
+
+func (r *RpcPool) checkAlive() {
 	for {
+		getAccount := &grpcpb.GetAccountByNameRequest{
+			AccountName: &prototype.AccountName{Value: probeName()},
+		}
 		//fmt.Println("@@@ start checkout alive, pool size:",len(r.pool))
-		for _, c := range r.pool {
+		for _, c := range r.clients() {
 			if c.IsAlive() {
 				continue
 			}
@@ -76,17 +131,13 @@ func dial(target string) (*grpc.ClientConn, error) {
 	return conn, err
 }
 
-func (r *RpcPool) push(c *Client) {
-	r.pool = append(r.pool, c)
-}
-
 func (r *RpcPool) GetClient() *Client {
+	mutex.Lock()
+	defer mutex.Unlock()
 	length := len(r.pool)
 	if length == 0 {
 		return nil
 	}
-	mutex.Lock()
-	defer mutex.Unlock()
 
 	if r.pool[0].isAlive() {
 		//fmt.Println("$$$ GetClient now alive ip:",r.pool[0].ip)
@@ -125,11 +176,37 @@ func (r *Client) isAlive() bool {
 	return r.alive
 }
 
+// Latency is the moving average duration of rpc calls on the current client.
+func (r *RpcPool) Latency() time.Duration {
+	c := r.GetClient()
+	if c == nil {
+		return 0
+	}
+	return c.Latency()
+}
+
+func (r *Client) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&r.latency))
+}
+
+func (r *Client) observe(start time.Time) {
+	elapsed := int64(time.Since(start))
+	for {
+		old := atomic.LoadInt64(&r.latency)
+		avg := old + (elapsed-old)/8
+		if atomic.CompareAndSwapInt64(&r.latency, old, avg) {
+			return
+		}
+	}
+}
+
 func (r *Client) BroadcastTrx(req *grpcpb.BroadcastTrxRequest) (*grpcpb.BroadcastTrxResponse, error) {
+	defer r.observe(time.Now())
 	return r.rpcClient.BroadcastTrx(context.Background(), req)
 }
 
 func (r *Client) GetUserTrxListByTime(req *grpcpb.GetUserTrxListByTimeRequest) (*grpcpb.GetUserTrxListByTimeResponse, error) {
+	defer r.observe(time.Now())
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.timeout)*time.Millisecond)
 	defer cancel()
 	res, err := r.rpcClient.GetUserTrxListByTime(ctx, req)
@@ -137,6 +214,7 @@ func (r *Client) GetUserTrxListByTime(req *grpcpb.GetUserTrxListByTimeRequest) (
 }
 
 func (r *Client) GetAccountByName(req *grpcpb.GetAccountByNameRequest) (*grpcpb.AccountResponse, error) {
+	defer r.observe(time.Now())
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.timeout)*time.Millisecond)
 	defer cancel()
 	res, err := r.rpcClient.GetAccountByName(ctx, req)
@@ -144,7 +222,7 @@ func (r *Client) GetAccountByName(req *grpcpb.GetAccountByNameRequest) (*grpcpb.
 }
 
 func (r *Client) GetStatisticsInfo(req *grpcpb.NonParamsRequest) (*grpcpb.GetStatResponse, error) {
-
+	defer r.observe(time.Now())
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.timeout)*time.Millisecond)
 	defer cancel()
 	res, err := r.rpcClient.GetStatisticsInfo(ctx, req)
@@ -152,8 +230,25 @@ func (r *Client) GetStatisticsInfo(req *grpcpb.NonParamsRequest) (*grpcpb.GetSta
 }
 
 func (r *Client) GetReward(req *grpcpb.GetBlockCashoutRequest) (*grpcpb.BlockCashoutResponse, error) {
+	defer r.observe(time.Now())
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.timeout)*time.Millisecond)
 	defer cancel()
 	res, err := r.rpcClient.GetBlockCashout(ctx, req)
 	return res, err
 }
+
+func (r *Client) GetPostInfoById(req *grpcpb.GetPostInfoByIdRequest) (*grpcpb.GetPostInfoByIdResponse, error) {
+	defer r.observe(time.Now())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.timeout)*time.Millisecond)
+	defer cancel()
+	res, err := r.rpcClient.GetPostInfoById(ctx, req)
+	return res, err
+}
+
+func (r *Client) GetTrxInfoById(req *grpcpb.GetTrxInfoByIdRequest) (*grpcpb.GetTrxInfoByIdResponse, error) {
+	defer r.observe(time.Now())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.timeout)*time.Millisecond)
+	defer cancel()
+	res, err := r.rpcClient.GetTrxInfoById(ctx, req)
+	return res, err
+}
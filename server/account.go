@@ -0,0 +1,139 @@
+package server
+
+import (
+	"fmt"
+	"github.com/coschain/contentos-go/prototype"
+	"github.com/coschain/contentos-go/rpc/pb"
+	"github.com/hashicorp/golang-lru"
+	"net/http"
+	"proxy/config"
+	"proxy/define"
+	"strconv"
+	"time"
+)
+
+const accountCacheSize = 10000
+
+// chainAccount is a cached GetAccountByName result, info is nil if the
+// account is not on chain.
+type chainAccount struct {
+	info    *grpcpb.AccountInfo
+	expires time.Time
+}
+
+var chainAccounts *lru.Cache
+
+func initAccountCache() error {
+	cache, err := lru.New(accountCacheSize)
+	if err != nil {
+		return err
+	}
+	chainAccounts = cache
+	return nil
+}
+
+// getChainAccount looks up an account on chain, results are kept for
+// AccountCacheTime seconds to protect the node.
+func getChainAccount(name string) (*grpcpb.AccountInfo, error) {
+	now := time.Now()
+	if v, ok := chainAccounts.Get(name); ok {
+		if a := v.(*chainAccount); now.Before(a.expires) {
+			return a.info, nil
+		}
+	}
+
+	c := rpcPool.GetClient()
+	resp, err := c.GetAccountByName(&grpcpb.GetAccountByNameRequest{
+		AccountName: &prototype.AccountName{Value: name},
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("rpc GetAccountByName error:%v, accountName:%v", err, name))
+		c.SetAlive(false)
+		return nil, err
+	}
+	info := resp.GetInfo()
+	if info.GetAccountName() == nil {
+		info = nil
+	}
+	ttl := time.Duration(config.GetConfig().AccountCacheTime) * time.Second
+	chainAccounts.Add(name, &chainAccount{info: info, expires: now.Add(ttl)})
+	return info, nil
+}
+
+func getAccountStatus(id string) (string, error) {
+	status, err := dbInstance.HGETString(id, define.AccountStatus)
+	if status == "" {
+		status = define.AccountUnknown
+	}
+	return status, err
+}
+
+/**
+ * 账号信息
+ *   status: pending 等待上链, on_chain 已上链, failed 上链失败, repaired 已修复, unknown 状态未记录
+ *   reward 为代理统计的 vest 奖励，其余字段来自链上（短时间缓存）
+ *   on_chain: yes 在链上, no 不在链上, unknown 链节点查询失败，此时只返回 redis 中的字段
+ *   stamina 链节点的账号接口没有提供，恒为 null，vote_power 为链上的投票能量
+ */
+func accountInfo(wr http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(wr, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	res := map[string]interface{}{}
+	defer retGetWriter(r, wr, time.Now(), res)
+
+	id := r.FormValue("id")
+	typeInt, err := strconv.ParseInt(r.FormValue("type"), 10, 32)
+	if err != nil || !checkType(typeInt, r) {
+		res["ret"] = ParamError
+		return
+	}
+	if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+		res["ret"] = ParamError
+		return
+	}
+	id = getSpecificPrefix("id", typeInt) + id
+
+	account, err := dbInstance.HGETALL(id)
+	if err != nil {
+		res["ret"] = ServerError
+		return
+	}
+	if len(account) == 0 {
+		res["ret"] = IdNotExist
+		return
+	}
+	name := account[define.Name]
+	status := account[define.AccountStatus]
+	if status == "" {
+		status = define.AccountUnknown
+	}
+	reward, _ := strconv.ParseUint(account[define.Reward], 10, 64)
+
+	res["ret"] = OK
+	res["name"] = name
+	res["status"] = status
+	res["reward"] = reward
+	res["stamina"] = nil
+
+	info, err := getChainAccount(name)
+	switch {
+	case err != nil:
+		res["on_chain"] = "unknown"
+		return
+	case info == nil:
+		res["on_chain"] = "no"
+		return
+	}
+	res["on_chain"] = "yes"
+	res["public_key"] = info.GetPublicKey().ToWIF()
+	res["coin"] = info.GetCoin().GetValue()
+	res["vest"] = info.GetVest().GetValue()
+	res["vote_power"] = info.GetVotePower()
+	res["post_count"] = info.GetPostCount()
+	res["follower_count"] = info.GetFollowerCount()
+	res["following_count"] = info.GetFollowingCount()
+	res["created_time"] = info.GetCreatedTime().GetUtcSeconds()
+	return
+}
@@ -0,0 +1,345 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"proxy/config"
+	"proxy/job"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	adminListener net.Listener
+	draining      int32
+)
+
+func isDraining() bool {
+	return atomic.LoadInt32(&draining) == 1
+}
+
+// initAdmin starts the operator api on its own address.
+func initAdmin(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/queues", adminAuth(adminQueues))
+	mux.HandleFunc("/admin/peek", adminAuth(adminPeek))
+	mux.HandleFunc("/admin/age", adminAuth(adminAge))
+	mux.HandleFunc("/admin/pause", adminAuth(func(w http.ResponseWriter, r *http.Request) {
+		adminPause(w, r, true)
+	}))
+	mux.HandleFunc("/admin/resume", adminAuth(func(w http.ResponseWriter, r *http.Request) {
+		adminPause(w, r, false)
+	}))
+	mux.HandleFunc("/admin/deadletters", adminAuth(adminDeadLetters))
+	mux.HandleFunc("/admin/deadletters/requeue", adminAuth(func(w http.ResponseWriter, r *http.Request) {
+		adminDeadLetter(w, r, true)
+	}))
+	mux.HandleFunc("/admin/deadletters/discard", adminAuth(func(w http.ResponseWriter, r *http.Request) {
+		adminDeadLetter(w, r, false)
+	}))
+	mux.HandleFunc("/admin/drain", adminAuth(adminDrain))
+	mux.HandleFunc("/admin/reload", adminAuth(adminReload))
+	mux.HandleFunc("/admin/reconcile", adminAuth(adminReconcile))
+
+	server := &http.Server{Handler: mux, ReadTimeout: httpReadTimeout * time.Second, WriteTimeout: httpWriteTimeout * time.Second}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Error(fmt.Sprintf("admin listen addr:%v error:%v", addr, err))
+		return err
+	}
+	adminListener = l
+	go func() {
+		log.Info(fmt.Sprintf("start admin listen addr: %v", addr))
+		if err := server.Serve(l); err != nil && !closed {
+			log.Error(fmt.Sprintf("admin serve addr:%v error:%v", addr, err))
+		}
+	}()
+	return nil
+}
+
+// adminAuth requires the configured token in the X-Admin-Token header.
+func adminAuth(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := []byte(config.GetConfig().AdminToken)
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), token) != 1 {
+			http.Error(w, http.StatusText(401), http.StatusUnauthorized)
+			return
+		}
+		h(w, r)
+	}
+}
+
+/**
+ * 队列与 worker 状态
+ */
+func adminQueues(wr http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(wr, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	res := map[string]interface{}{}
+	defer retGetWriter(r, wr, time.Now(), res)
+
+	res["ret"] = OK
+	res["scheduler"] = scheduler.Stat()
+	res["draining"] = isDraining()
+	return
+}
+
+/**
+ * 查看等待中的消息
+ */
+func adminPeek(wr http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(wr, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	res := map[string]interface{}{}
+	defer retGetWriter(r, wr, time.Now(), res)
+
+	limit := 20
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			res["ret"] = ParamError
+			return
+		}
+		limit = n
+	}
+
+	res["ret"] = OK
+	res["list"] = scheduler.Peek(limit)
+	return
+}
+
+/**
+ * 消息等待时长分布
+ */
+func adminAge(wr http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(wr, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	res := map[string]interface{}{}
+	defer retGetWriter(r, wr, time.Now(), res)
+
+	res["ret"] = OK
+	res["age"] = job.AgeHistogram()
+	return
+}
+
+/**
+ * 暂停/恢复某个 worker 或某种消息
+ *   params: worker 或 type 二选一
+ */
+func adminPause(wr http.ResponseWriter, r *http.Request, paused bool) {
+	if r.Method != "POST" {
+		http.Error(wr, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pStr := ""
+	res := map[string]interface{}{}
+	defer retPostWriter(r, wr, &pStr, time.Now(), res)
+	if err := r.ParseForm(); err != nil {
+		res["ret"] = ParamError
+		return
+	}
+	pStr = r.Form.Encode()
+	workerStr := r.FormValue("worker")
+	msgType := r.FormValue("type")
+
+	var err error
+	switch {
+	case workerStr != "":
+		index, perr := strconv.Atoi(workerStr)
+		if perr != nil {
+			res["ret"] = ParamError
+			return
+		}
+		err = scheduler.PauseWorker(index, paused)
+	case msgType != "":
+		err = scheduler.PauseType(msgType, paused)
+	default:
+		res["ret"] = ParamError
+		return
+	}
+	if err != nil {
+		res["ret"] = ParamError
+		res["error"] = err.Error()
+		return
+	}
+	res["ret"] = OK
+	return
+}
+
+/**
+ * 死信列表
+ */
+func adminDeadLetters(wr http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(wr, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	res := map[string]interface{}{}
+	defer retGetWriter(r, wr, time.Now(), res)
+
+	params := r.URL.Query()
+	start, limit := 0, 50
+	var err error
+	if s := params.Get("start"); s != "" {
+		if start, err = strconv.Atoi(s); err != nil || start < 0 {
+			res["ret"] = ParamError
+			return
+		}
+	}
+	if s := params.Get("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			res["ret"] = ParamError
+			return
+		}
+	}
+
+	list, err := job.DeadLetters(dbInstance, start, start+limit-1)
+	if err != nil {
+		res["ret"] = ServerError
+		return
+	}
+	res["ret"] = OK
+	res["list"] = list
+	return
+}
+
+/**
+ * 重新投递或丢弃死信
+ */
+func adminDeadLetter(wr http.ResponseWriter, r *http.Request, requeue bool) {
+	if r.Method != "POST" {
+		http.Error(wr, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pStr := ""
+	res := map[string]interface{}{}
+	defer retPostWriter(r, wr, &pStr, time.Now(), res)
+	if err := r.ParseForm(); err != nil {
+		res["ret"] = ParamError
+		return
+	}
+	pStr = r.Form.Encode()
+	requestId := r.FormValue("request_id")
+	if requestId == "" {
+		res["ret"] = ParamError
+		return
+	}
+
+	var found bool
+	var err error
+	if requeue {
+		found, err = job.RequeueDeadLetter(dbInstance, dispatcher, requestId)
+	} else {
+		found, err = job.DiscardDeadLetter(dbInstance, requestId)
+	}
+	if err != nil {
+		log.Error(fmt.Sprintf("dead letter request:%v requeue:%v error:%v", requestId, requeue, err))
+		res["ret"] = ServerError
+		return
+	}
+	if !found {
+		res["ret"] = RequestNotExist
+		return
+	}
+	res["ret"] = OK
+	return
+}
+
+/**
+ * 优雅下线
+ *   不再接收新请求、交还主节点身份与 redis 分区，已入队的消息继续处理，
+ *   通过 /admin/queues 的 draining 与 Pending 观察进度
+ */
+func adminDrain(wr http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(wr, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pStr := ""
+	res := map[string]interface{}{}
+	defer retPostWriter(r, wr, &pStr, time.Now(), res)
+
+	if atomic.CompareAndSwapInt32(&draining, 0, 1) {
+		log.Info("start draining")
+		leader.Resign()
+		if consumer != nil {
+			go consumer.Stop()
+		}
+	}
+	res["ret"] = OK
+	res["pending"] = scheduler.Pending()
+	return
+}
+
+/**
+ * 重新加载配置
+ *   changed 为已生效的配置项，restart 为需要重启才能生效的配置项
+ */
+func adminReload(wr http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(wr, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pStr := ""
+	res := map[string]interface{}{}
+	defer retPostWriter(r, wr, &pStr, time.Now(), res)
+
+	changed, restart, err := Reload()
+	if err != nil {
+		res["ret"] = ServerError
+		res["error"] = err.Error()
+		return
+	}
+	res["ret"] = OK
+	res["changed"] = changed
+	res["restart"] = restart
+	return
+}
+
+/**
+ * redis 与链上数据对账
+ *   GET 返回最近一次的报告，POST 在后台开始一次对账，repair=1 时修复缺失的数据
+ */
+func adminReconcile(wr http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		res := map[string]interface{}{}
+		defer retGetWriter(r, wr, time.Now(), res)
+
+		report, err := job.LastReconcileReport(dbInstance)
+		if err != nil {
+			res["ret"] = ServerError
+			return
+		}
+		res["ret"] = OK
+		res["report"] = report
+	case "POST":
+		pStr := ""
+		res := map[string]interface{}{}
+		defer retPostWriter(r, wr, &pStr, time.Now(), res)
+		if err := r.ParseForm(); err != nil {
+			res["ret"] = ParamError
+			return
+		}
+		pStr = r.Form.Encode()
+		repair := r.FormValue("repair") == "1"
+
+		go func() {
+			if _, err := reconciler.Sweep(repair); err != nil {
+				log.Error(fmt.Sprintf("reconcile error:%v", err))
+			}
+		}()
+		res["ret"] = OK
+	default:
+		http.Error(wr, "Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -3,42 +3,15 @@ package server
 import (
 	"fmt"
 	"github.com/coschain/contentos-go/prototype"
-	"math/rand"
 	"net/http"
+	"proxy/config"
 	"proxy/define"
 	"proxy/job"
-	"proxy/utils"
 	"strconv"
 	"strings"
 	"time"
 )
 
-func makeValidateName(name string) string {
-
-	buf := []byte(name)
-	var newName strings.Builder
-	for i, val := range buf {
-		if !isValidNameChar(val) {
-			continue
-		} else {
-			newName.WriteString(string(name[i]))
-		}
-	}
-	return newName.String()
-}
-
-func isValidNameChar(c byte) bool {
-	if c >= '0' && c <= '9' {
-		return true
-	} else if c >= 'a' && c <= 'z' {
-		return true
-	} else if c >= 'A' && c <= 'Z' {
-		return true
-	} else {
-		return false
-	}
-}
-
 func checkAccountExist(id string) (bool, error) {
 	exist, errKey := dbInstance.EXISTS(id)
 	return exist, errKey
@@ -49,11 +22,13 @@ func getAccountName(id string) (string, error) {
 	return name, errKey
 }
 
-func checkType(t int64) bool {
-	if t != PhotoGrid && t != Contentos && t != Game2048 {
+// checkType reports whether app t is registered and may call the endpoint of r.
+func checkType(t int64, r *http.Request) bool {
+	app := config.GetConfig().AppMap[t]
+	if app == nil {
 		return false
 	}
-	return true
+	return app.Enabled(strings.TrimPrefix(r.URL.Path, "/api/"))
 }
 
 /**
@@ -98,7 +73,7 @@ func game2048(wr http.ResponseWriter, r *http.Request) {
 
 	// check typeid
 	typeInt, err := strconv.ParseInt(typeStr, 10, 32)
-	if err != nil || !checkType(typeInt) {
+	if err != nil || !checkType(typeInt, r) {
 		res["ret"] = ParamError
 		return
 	}
@@ -130,26 +105,17 @@ func game2048(wr http.ResponseWriter, r *http.Request) {
 
 	// if account isnot exist create it
 	idPrefix := getSpecificPrefix("id", typeInt)
-	namePrefix := getSpecificPrefix("name", typeInt)
 
 	winnerIdStr = idPrefix + winnerIdStr
-	winnerNameStr = namePrefix + winnerNameStr
-	newWinnerNameStr := makeValidateName(winnerNameStr)
-	if len(newWinnerNameStr) != 0 {
-		winnerNameStr = utils.GenerateName(newWinnerNameStr)
-	} else {
-		len := rand.Intn(8) + 8
-		winnerNameStr = utils.RandStringBytes(len)
+	if winnerNameStr, err = accountName(winnerIdStr, typeInt, winnerNameStr); err != nil {
+		res["ret"] = ServerError
+		return
 	}
 
 	loserIdStr = idPrefix + loserIdStr
-	loserNameStr = namePrefix + loserNameStr
-	newLoserNameStr := makeValidateName(loserNameStr)
-	if len(newLoserNameStr) != 0 {
-		loserNameStr = utils.GenerateName(newLoserNameStr)
-	} else {
-		len := rand.Intn(8) + 8
-		loserNameStr = utils.RandStringBytes(len)
+	if loserNameStr, err = accountName(loserIdStr, typeInt, loserNameStr); err != nil {
+		res["ret"] = ServerError
+		return
 	}
 
 	msg := &job.Game2048Msg{
@@ -162,9 +128,13 @@ func game2048(wr http.ResponseWriter, r *http.Request) {
 		Cos:   cos,
 		Gid:   gameIdStr}
 	msg.AppStr = getSpecificPrefix("", typeInt)
-	sendMsg(loserId, msg)
-
 	res["ret"] = OK
+	requestId, err := sendMsg(loserId, msg)
+	if err != nil {
+		res["ret"] = ServerError
+		return
+	}
+	res["request_id"] = requestId
 	return
 }
 
@@ -194,7 +164,7 @@ func actionList(wr http.ResponseWriter, r *http.Request) {
 	}
 
 	typeInt, err := strconv.ParseInt(typeStr, 10, 32)
-	if err != nil || !checkType(typeInt) {
+	if err != nil || !checkType(typeInt, r) {
 		res["ret"] = ParamError
 		return
 	}
@@ -297,7 +267,7 @@ func signIn(wr http.ResponseWriter, r *http.Request) {
 	}
 
 	typeInt, err := strconv.ParseInt(typeStr, 10, 32)
-	if err != nil || !checkType(typeInt) {
+	if err != nil || !checkType(typeInt, r) {
 		res["ret"] = ParamError
 		return
 	}
@@ -339,8 +309,12 @@ func signIn(wr http.ResponseWriter, r *http.Request) {
 	msg.AppStr = getSpecificPrefix("", typeInt)
 
 	res["ret"] = OK
-
-	sendMsg(userId, msg)
+	requestId, err := sendMsg(userId, msg)
+	if err != nil {
+		res["ret"] = ServerError
+		return
+	}
+	res["request_id"] = requestId
 	return
 }
 
@@ -368,7 +342,7 @@ func createAccount(wr http.ResponseWriter, r *http.Request) {
 	}
 
 	typeInt, err := strconv.ParseInt(typeStr, 10, 32)
-	if err != nil || !checkType(typeInt) {
+	if err != nil || !checkType(typeInt, r) {
 		res["ret"] = ParamError
 		return
 	}
@@ -382,28 +356,6 @@ func createAccount(wr http.ResponseWriter, r *http.Request) {
 	idPrefix := getSpecificPrefix("id", typeInt)
 	id = idPrefix + id
 
-	namePrefix := getSpecificPrefix("name", typeInt)
-	name = namePrefix + name
-
-	newName := makeValidateName(name)
-	if len(newName) != 0 { // got a valid subname
-		name = utils.GenerateName(newName)
-	} else { // all char invalid
-		len := rand.Intn(8) + 8 // 8 ~ 15
-		name = utils.RandStringBytes(len)
-	}
-
-	// check name exist
-	nameExist, errName := dbInstance.EXISTS(name)
-	if errName != nil {
-		res["ret"] = ServerError
-		return
-	}
-	if nameExist {
-		res["ret"] = ServerError
-		return
-	}
-
 	// check exist
 	exist, err := checkAccountExist(id)
 	if err != nil {
@@ -415,12 +367,24 @@ func createAccount(wr http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	name, err = claimName(id, typeInt, name)
+	if err != nil {
+		log.Error(fmt.Sprintf("claim name for account:%v error:%v", id, err))
+		res["ret"] = ServerError
+		return
+	}
+
 	res["ret"] = OK
 
 	msg := &job.AccountMsg{Id: id, Name: name}
 	msg.AppStr = getSpecificPrefix("", typeInt)
 
-	sendMsg(userId, msg)
+	requestId, err := sendMsg(userId, msg)
+	if err != nil {
+		res["ret"] = ServerError
+		return
+	}
+	res["request_id"] = requestId
 	return
 }
 
@@ -444,12 +408,22 @@ func post(wr http.ResponseWriter, r *http.Request) {
 	content := r.FormValue("content")
 	tag := r.FormValue("tag")
 	typeStr := r.FormValue("type")
+	publishAtStr := r.FormValue("publish_at") // 定时发布的时间戳（秒），可选
 
 	if postIdStr == "" || id == "" || content == "" || typeStr == "" {
 		res["ret"] = ParamError
 		return
 	}
 
+	var publishAt int64
+	if publishAtStr != "" {
+		var err error
+		if publishAt, err = strconv.ParseInt(publishAtStr, 10, 64); err != nil {
+			res["ret"] = ParamError
+			return
+		}
+	}
+
 	// parse postIdStr to uint64
 	_, err := strconv.ParseUint(postIdStr, 10, 64)
 	if err != nil {
@@ -457,7 +431,7 @@ func post(wr http.ResponseWriter, r *http.Request) {
 		return
 	}
 	typeInt, err := strconv.ParseInt(typeStr, 10, 32)
-	if err != nil || !checkType(typeInt) {
+	if err != nil || !checkType(typeInt, r) {
 		res["ret"] = ParamError
 		return
 	}
@@ -487,13 +461,18 @@ func post(wr http.ResponseWriter, r *http.Request) {
 	msg := &job.PostMsg{Id: id, PostId: postIdStr, Title: title, Content: content, Tag: tag}
 	msg.AppStr = getSpecificPrefix("", typeInt)
 
-	// check exist
+	// check exist, a scheduled post holds its post id until it is written
 	postExist, errPost := dbInstance.EXISTS(postIdStr)
 	if errPost != nil {
 		res["ret"] = ServerError
 		return
 	}
-	if postExist {
+	reserved, errPost := dbInstance.EXISTS(define.PostReservePrefix + postIdStr)
+	if errPost != nil {
+		res["ret"] = ServerError
+		return
+	}
+	if postExist || reserved {
 		res["ret"] = PostIdDuplicate
 		return
 	}
@@ -508,9 +487,115 @@ func post(wr http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if publishAt > time.Now().Unix() {
+		// kept a day past publishing, by then the post itself is written
+		expire := int(publishAt-time.Now().Unix()) + 24*3600
+		ok, err := dbInstance.SETNX(define.PostReservePrefix+postIdStr, id, expire)
+		if err != nil {
+			res["ret"] = ServerError
+			return
+		}
+		if !ok {
+			res["ret"] = PostIdDuplicate
+			return
+		}
+		requestId, err := delayQueue.Schedule(userId, msg, time.Unix(publishAt, 0))
+		if err != nil {
+			log.Error(fmt.Sprintf("schedule post id:%v post_id:%v error:%v", id, postIdStr, err))
+			dbInstance.DEL(define.PostReservePrefix + postIdStr)
+			res["ret"] = ServerError
+			return
+		}
+		res["ret"] = OK
+		res["request_id"] = requestId
+		return
+	}
+
+	res["ret"] = OK
+	requestId, err := sendMsg(userId, msg)
+	if err != nil {
+		res["ret"] = ServerError
+		return
+	}
+	res["request_id"] = requestId
+	return
+}
+
+/**
+ * 取消尚未执行的定时消息
+ */
+func cancelSchedule(wr http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(wr, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	pStr := ""
+	res := map[string]interface{}{}
+	defer retPostWriter(r, wr, &pStr, time.Now(), res)
+	if err := r.ParseForm(); err != nil {
+		log.Error(fmt.Sprintf("r.ParseForm() failed(%v)", err))
+		res["ret"] = ParamError
+		return
+	}
+	pStr = r.Form.Encode()
+	requestId := r.FormValue("request_id")
+	typeInt, err := strconv.ParseInt(r.FormValue("type"), 10, 32)
+	if err != nil || requestId == "" || !checkType(typeInt, r) {
+		res["ret"] = ParamError
+		return
+	}
+
+	// only the app that scheduled the request may cancel it
+	m, err := delayQueue.Cancel(requestId, getSpecificPrefix("", typeInt))
+	if err != nil {
+		res["ret"] = ServerError
+		return
+	}
+	if m == nil {
+		res["ret"] = RequestNotExist
+		return
+	}
+	if p, ok := m.(*job.PostMsg); ok {
+		if err := dbInstance.DEL(define.PostReservePrefix + p.PostId); err != nil {
+			log.Error(fmt.Sprintf("DEL post reserve post_id:%v error:%v", p.PostId, err))
+		}
+	}
 	res["ret"] = OK
+	return
+}
+
+/**
+ * 查询请求状态
+ *   只能查询本 app 的请求，记录保存 7 天，过期或不属于本 app 时返回 RequestNotExist
+ */
+func requestStatus(wr http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(wr, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	res := map[string]interface{}{}
+	defer retGetWriter(r, wr, time.Now(), res)
+
+	requestId := r.FormValue("request_id")
+	typeInt, err := strconv.ParseInt(r.FormValue("type"), 10, 32)
+	if err != nil || requestId == "" || !checkType(typeInt, r) {
+		res["ret"] = ParamError
+		return
+	}
 
-	sendMsg(userId, msg)
+	status, err := job.GetStatus(dbInstance, requestId)
+	if err != nil {
+		res["ret"] = ServerError
+		return
+	}
+	if status == nil || status.App != getSpecificPrefix("", typeInt) {
+		res["ret"] = RequestNotExist
+		return
+	}
+	res["ret"] = OK
+	res["request_id"] = requestId
+	res["status"] = status.Status
+	res["msg_type"] = status.Type
 	return
 }
 
@@ -538,7 +623,7 @@ func like(wr http.ResponseWriter, r *http.Request) {
 	}
 
 	typeInt, err := strconv.ParseInt(typeStr, 10, 32)
-	if err != nil || !checkType(typeInt) {
+	if err != nil || !checkType(typeInt, r) {
 		res["ret"] = ParamError
 		return
 	}
@@ -575,7 +660,12 @@ func like(wr http.ResponseWriter, r *http.Request) {
 	}
 	if !postExist {
 		res["ret"] = PostIdNotExist
-		sendFakeLikeMsg(userId, id, app)
+		requestId, err := sendFakeLikeMsg(userId, id, app)
+		if err != nil {
+			res["ret"] = ServerError
+			return
+		}
+		res["request_id"] = requestId
 		return
 	}
 
@@ -595,21 +685,24 @@ func like(wr http.ResponseWriter, r *http.Request) {
 	msg.AppStr = app
 
 	res["ret"] = OK
-
-	sendMsg(userId, msg)
+	requestId, err := sendMsg(userId, msg)
+	if err != nil {
+		res["ret"] = ServerError
+		return
+	}
+	res["request_id"] = requestId
 	return
 }
 
-func sendFakeLikeMsg(id uint64, combineId, app string) {
+func sendFakeLikeMsg(id uint64, combineId, app string) (string, error) {
 	name, err := dbInstance.HGETString(combineId, define.Name)
 	if err != nil || name == "" {
 		log.Error(fmt.Sprintf("get account name error:%v account:%v name:%v", err, id, name))
-		return
+		return "", nil
 	}
 	msg := &job.FakeLikeMsg{Id: combineId, Name: name}
 	msg.AppStr = app
-	path := id % uint64(jobCount)
-	jobs[path].Put(msg)
+	return sendMsg(id, msg)
 }
 
 func comment(wr http.ResponseWriter, r *http.Request) {
@@ -645,7 +738,7 @@ func comment(wr http.ResponseWriter, r *http.Request) {
 	}
 
 	typeInt, err := strconv.ParseInt(typeStr, 10, 32)
-	if err != nil || !checkType(typeInt) {
+	if err != nil || !checkType(typeInt, r) {
 		res["ret"] = ParamError
 		return
 	}
@@ -696,7 +789,12 @@ func comment(wr http.ResponseWriter, r *http.Request) {
 	}
 	if !postExist {
 		res["ret"] = PostIdNotExist
-		sendFakeCommentMsg(userId, id, commentContent, app)
+		requestId, err := sendFakeCommentMsg(userId, id, commentContent, app)
+		if err != nil {
+			res["ret"] = ServerError
+			return
+		}
+		res["request_id"] = requestId
 		return
 	}
 
@@ -705,20 +803,24 @@ func comment(wr http.ResponseWriter, r *http.Request) {
 	msg := &job.CommentMsg{Id: id, PostId: postIdStr, CommentId: commentIdStr, Content: commentContent}
 	msg.AppStr = app
 
-	sendMsg(userId, msg)
+	requestId, err := sendMsg(userId, msg)
+	if err != nil {
+		res["ret"] = ServerError
+		return
+	}
+	res["request_id"] = requestId
 	return
 }
 
-func sendFakeCommentMsg(id uint64, combineId, content, app string) {
+func sendFakeCommentMsg(id uint64, combineId, content, app string) (string, error) {
 	name, err := dbInstance.HGETString(combineId, define.Name)
 	if err != nil || name == "" {
 		log.Error(fmt.Sprintf("get account name error:%v account:%v name:%v", err, id, name))
-		return
+		return "", nil
 	}
 	msg := &job.FakeCommentMsg{Id: combineId, Name: name, Content: content}
 	msg.AppStr = app
-	path := id % uint64(jobCount)
-	jobs[path].Put(msg)
+	return sendMsg(id, msg)
 }
 
 func follow(wr http.ResponseWriter, r *http.Request) {
@@ -757,7 +859,12 @@ func follow(wr http.ResponseWriter, r *http.Request) {
 	msg := &job.FollowMsg{Uid: uid, Fuid: fuid, UniqueFollow: uniqueFollow, Cancel: false}
 	msg.AppStr = getSpecificPrefix("", typeInt)
 
-	sendMsg(userId, msg)
+	requestId, err := sendMsg(userId, msg)
+	if err != nil {
+		res["ret"] = ServerError
+		return
+	}
+	res["request_id"] = requestId
 	return
 }
 
@@ -797,7 +904,12 @@ func unfollow(wr http.ResponseWriter, r *http.Request) {
 	msg := &job.FollowMsg{Uid: uid, Fuid: fuid, UniqueFollow: uniqueFollow, Cancel: true}
 	msg.AppStr = getSpecificPrefix("", typeInt)
 
-	sendMsg(userId, msg)
+	requestId, err := sendMsg(userId, msg)
+	if err != nil {
+		res["ret"] = ServerError
+		return
+	}
+	res["request_id"] = requestId
 	return
 }
 
@@ -806,7 +918,7 @@ func checkFollow(res map[string]interface{}, r *http.Request) (uidInt uint64, ui
 	fuid = r.FormValue("fuid")
 	typeStr := r.FormValue("type")
 	typeInt, err := strconv.ParseInt(typeStr, 10, 32)
-	if err != nil || !checkType(typeInt) {
+	if err != nil || !checkType(typeInt, r) {
 		res["ret"] = ParamError
 		return
 	}
@@ -883,7 +995,7 @@ func getName(wr http.ResponseWriter, r *http.Request) {
 
 	typeStr := r.FormValue("type")
 	typeInt, err := strconv.ParseInt(typeStr, 10, 32)
-	if err != nil || !checkType(typeInt) {
+	if err != nil || !checkType(typeInt, r) {
 		res["ret"] = ParamError
 		return
 	}
@@ -913,18 +1025,28 @@ func getName(wr http.ResponseWriter, r *http.Request) {
 		res["ret"] = ServerError
 		return
 	}
+	status, err := getAccountStatus(id)
+	if err != nil {
+		res["ret"] = ServerError
+		return
+	}
 
 	res["ret"] = OK
 	res["name"] = name
+	res["status"] = status
 	return
 }
 
 /**
  * 将整合数据传递给job处理
  */
-func sendMsg(id uint64, m interface{}) {
-	path := id % uint64(jobCount)
-	jobs[path].Put(m)
+func sendMsg(id uint64, m interface{}) (string, error) {
+	requestId := job.Stamp(m)
+	if err := dispatcher.Dispatch(id, m); err != nil {
+		log.Error(fmt.Sprintf("dispatch id:%v msg:%+v error:%v", id, m, err))
+		return "", err
+	}
+	return requestId, nil
 }
 
 /**
@@ -957,14 +1079,8 @@ func getSpecificPrefix(api string, t int64) string {
 	default:
 	}
 
-	switch t {
-	case PhotoGrid:
-		prefix += define.PGStr
-	case Contentos:
-		prefix += define.ContentosStr
-	case Game2048:
-		prefix += define.Game2048Str
-	default:
+	if app := config.GetConfig().AppMap[t]; app != nil {
+		prefix += app.Name
 	}
 	return prefix
 }
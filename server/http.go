@@ -4,12 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/time/rate"
 	"net"
 	"net/http"
 	"os"
 	"proxy/config"
 	"proxy/database"
+	"proxy/define"
 	"proxy/job"
 	"proxy/rpc"
 	"strings"
@@ -37,12 +37,7 @@ const (
 	FollowSelf         = 3009
 	Signed             = 3010
 	GameIdExist        = 3011
-)
-
-const (
-	PhotoGrid = 1
-	Contentos = 2
-	Game2048  = 3
+	RequestNotExist    = 3012
 )
 
 var (
@@ -51,9 +46,15 @@ var (
 	dbInstance   *database.DB
 	jobs         []*job.Job
 	rJob         *job.RewardJob
+	rpcPool      *rpc.RpcPool
+	leader       *job.Leader
+	scheduler    *job.Scheduler
+	dispatcher   job.Dispatcher
+	consumer     *job.PartitionConsumer
+	delayQueue   *job.DelayQueue
+	reconciler   *job.Reconciler
 	log          *logrus.Logger
 	jobCount     int
-	limiter      *rate.Limiter
 )
 
 func init() {
@@ -73,18 +74,63 @@ func Init(db *database.DB, apiLogFile *os.File, jobLogFile *os.File) error {
 	jobCount = conf.JobCount
 
 	pool := rpc.NewRpcPool(conf.RpcAddr, conf.RpcTimeOut)
+	rpcPool = pool
 	for i := 0; i < jobCount; i++ {
 		jobInstance := job.NewJob(db, jobLogFile, i, pool)
 		jobs = append(jobs, jobInstance)
-		go jobInstance.Start()
 	}
 
+	// without JobMinCount the pool keeps JobCount workers all the time
+	jobMin := conf.JobMinCount
+	if jobMin == 0 {
+		jobMin = jobCount
+	}
+	scheduler = job.NewScheduler(jobs, jobMin)
+	scheduler.Start()
+	if jobMin < jobCount {
+		go scheduler.Autoscale(pool.Latency, time.Duration(conf.JobMaxRpcLatency)*time.Millisecond)
+	}
+
+	leaseTime := time.Duration(conf.LeaderLeaseTime) * time.Second
+	if conf.QueueMode == define.QueueRedis {
+		// messages go through redis partitions so a user is ordered cluster-wide
+		dispatcher = job.NewRedisDispatcher(db, conf.PartitionCount)
+		consumer = job.NewPartitionConsumer(db, scheduler, conf.InstanceId, conf.PartitionCount, leaseTime)
+		go consumer.Start()
+	} else {
+		dispatcher = job.NewLocalDispatcher(db, scheduler)
+	}
+
+	// leader election for singleton jobs
+	leader = job.NewLeader(db, define.LeaderLease, conf.InstanceId, leaseTime)
+	go leader.Start()
+
+	// delayed messages are released by the leader only
+	delayQueue = job.NewDelayQueue(db, dispatcher, leader)
+	go delayQueue.Start()
+
 	// reward query job
-	rJob = job.NewRewardJob(db, pool)
+	rJob = job.NewRewardJob(db, pool, leader)
 	go rJob.Start()
 
+	// redis to chain reconciliation, on demand through the admin api
+	reconciler = job.NewReconciler(jobs[0], leader, dispatcher)
+	if conf.ReconcileInterval > 0 {
+		go reconciler.Start()
+	}
+
+	// short lived cache of chain accounts for the info api
+	if err := initAccountCache(); err != nil {
+		return err
+	}
+
+	// cached chain accounts are dropped when the chain is reset
+	go job.WatchChainReset(db, chainAccounts.Purge)
+
 	// rate limiter
-	limiter = rate.NewLimiter(rate.Limit(conf.TokenPerSecond), conf.TokenMax)
+	if err := initLimitRules(); err != nil {
+		return err
+	}
 
 	// init handler
 	httpServeMux := initHttpHandler()
@@ -107,77 +153,96 @@ func Init(db *database.DB, apiLogFile *os.File, jobLogFile *os.File) error {
 		}
 	}()
 
-	return nil
-}
-
-func checkLimit(w http.ResponseWriter, r *http.Request) bool {
-	if limiter.Allow() == false {
-		http.Error(w, http.StatusText(429), http.StatusTooManyRequests)
-		return false
+	if conf.AdminListenAddr != "" {
+		if err := initAdmin(conf.AdminListenAddr); err != nil {
+			return err
+		}
 	}
-	return true
+
+	return nil
 }
 
 // initHttpHandler register all controller http handlers.
 func initHttpHandler() *http.ServeMux {
 	httpServeMux := http.NewServeMux()
 	httpServeMux.HandleFunc("/api/game2048", func(w http.ResponseWriter, r *http.Request) {
-		if !checkLimit(w, r) {
+		if !checkAuth(w, r) || !checkLimit(w, r) {
 			return
 		}
 		game2048(w, r)
 	})
 	httpServeMux.HandleFunc("/api/actionlist", func(w http.ResponseWriter, r *http.Request) {
-		if !checkLimit(w, r) {
+		if !checkAuth(w, r) || !checkLimit(w, r) {
 			return
 		}
 		actionList(w, r)
 	})
 	httpServeMux.HandleFunc("/api/signin", func(w http.ResponseWriter, r *http.Request) {
-		if !checkLimit(w, r) {
+		if !checkAuth(w, r) || !checkLimit(w, r) {
 			return
 		}
 		signIn(w, r)
 	})
 	httpServeMux.HandleFunc("/api/account", func(w http.ResponseWriter, r *http.Request) {
-		if !checkLimit(w, r) {
+		if !checkAuth(w, r) || !checkLimit(w, r) {
 			return
 		}
 		createAccount(w, r)
 	})
 	httpServeMux.HandleFunc("/api/post", func(w http.ResponseWriter, r *http.Request) {
-		if !checkLimit(w, r) {
+		if !checkAuth(w, r) || !checkLimit(w, r) {
 			return
 		}
 		post(w, r)
 	})
+	httpServeMux.HandleFunc("/api/schedule/cancel", func(w http.ResponseWriter, r *http.Request) {
+		if !checkAuth(w, r) || !checkLimit(w, r) {
+			return
+		}
+		cancelSchedule(w, r)
+	})
+	httpServeMux.HandleFunc("/api/request/status", func(w http.ResponseWriter, r *http.Request) {
+		if !checkAuth(w, r) || !checkLimit(w, r) {
+			return
+		}
+		requestStatus(w, r)
+	})
 	httpServeMux.HandleFunc("/api/like", func(w http.ResponseWriter, r *http.Request) {
-		if !checkLimit(w, r) {
+		if !checkAuth(w, r) || !checkLimit(w, r) {
 			return
 		}
 		like(w, r)
 	})
 	httpServeMux.HandleFunc("/api/comment", func(w http.ResponseWriter, r *http.Request) {
-		if !checkLimit(w, r) {
+		if !checkAuth(w, r) || !checkLimit(w, r) {
 			return
 		}
 		comment(w, r)
 	})
 	httpServeMux.HandleFunc("/api/follow", func(w http.ResponseWriter, r *http.Request) {
-		if !checkLimit(w, r) {
+		if !checkAuth(w, r) || !checkLimit(w, r) {
 			return
 		}
 		follow(w, r)
 	})
 	httpServeMux.HandleFunc("/api/unfollow", func(w http.ResponseWriter, r *http.Request) {
-		if !checkLimit(w, r) {
+		if !checkAuth(w, r) || !checkLimit(w, r) {
 			return
 		}
 		unfollow(w, r)
 	})
 	httpServeMux.HandleFunc("/api/getname", func(w http.ResponseWriter, r *http.Request) {
+		if !checkAuth(w, r) || !checkLimit(w, r) {
+			return
+		}
 		getName(w, r)
 	})
+	httpServeMux.HandleFunc("/api/account/info", func(w http.ResponseWriter, r *http.Request) {
+		if !checkAuth(w, r) || !checkLimit(w, r) {
+			return
+		}
+		accountInfo(w, r)
+	})
 	return httpServeMux
 }
 
@@ -229,14 +294,50 @@ func getClientIp(r *http.Request) string {
 	} else if remote == "" {
 		remote = r.Header.Get("X-Real-IP")
 	}
+	if remote == "" {
+		remote, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
 	return remote
 }
 
+// Reload reloads the config file and applies what changed to the running
+// server. The returned restart lists changed settings that are not applied.
+func Reload() (changed []string, restart []string, err error) {
+	// the pool is switched before the config, a failed dial keeps both
+	changed, restart, err = config.Reload(func(c *config.Config, changed []string) error {
+		for _, name := range changed {
+			if name == "RpcAddr" {
+				if err := rpcPool.Reset(c.RpcAddr); err != nil {
+					return fmt.Errorf("reset rpc pool error:%v", err)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Error(fmt.Sprintf("reload config error:%v", err))
+		return nil, nil, err
+	}
+	if err := initLimitRules(); err != nil {
+		return changed, restart, err
+	}
+	log.Info(fmt.Sprintf("reload config changed:%v need restart:%v", changed, restart))
+	return changed, restart, nil
+}
+
 // Close close the resource.
 func Close() {
 	closed = true
+	leader.Resign()
+	if consumer != nil {
+		consumer.Stop()
+	}
 	if err := httpListener.Close(); err != nil {
 		log.Error("l.Close() error(%v)", err)
 	}
 	httpListener = nil
+	if adminListener != nil {
+		adminListener.Close()
+		adminListener = nil
+	}
 }
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"fmt"
+	"github.com/hashicorp/golang-lru"
+	"golang.org/x/time/rate"
+	"math"
+	"net/http"
+	"proxy/config"
+	"proxy/define"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const bucketCacheSize = 100000
+
+/**
+ * 分维度限流
+ *   在全局限流之外，分别按 app 类型、客户端 ip、用户 id 维护令牌桶，
+ *   多实例部署时可以把令牌桶放在 redis 中共享。
+ */
+type limitRule struct {
+	name  string
+	rate  float64
+	burst int
+	key   func(r *http.Request) string
+}
+
+type bucket struct {
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+var (
+	limiter     *rate.Limiter
+	limitRules  []*limitRule
+	ruleMutex   sync.RWMutex
+	buckets     *lru.Cache
+	bucketMutex sync.Mutex
+)
+
+// initLimitRules (re)builds the limiters from the config. Buckets keep their
+// tokens across a reload, only a new burst caps them on the next take.
+func initLimitRules() error {
+	conf := config.GetConfig()
+	appRate := float64(conf.AppTokenPerSecond)
+	if !conf.AuthRequired && appRate > 0 {
+		// the type form value is only the client's claim without auth
+		log.Error("app rate limit ignored, it needs AuthRequired")
+		appRate = 0
+	}
+	var rules []*limitRule
+	for _, rule := range []*limitRule{
+		{name: "app", rate: appRate, burst: conf.AppTokenMax, key: limitAppKey},
+		{name: "ip", rate: float64(conf.IpTokenPerSecond), burst: conf.IpTokenMax, key: getClientIp},
+		{name: "user", rate: float64(conf.UserTokenPerSecond), burst: conf.UserTokenMax, key: limitUserKey},
+	} {
+		if rule.rate > 0 && rule.burst > 0 {
+			rules = append(rules, rule)
+		}
+	}
+
+	bucketMutex.Lock()
+	if buckets == nil {
+		cache, err := lru.New(bucketCacheSize)
+		if err != nil {
+			bucketMutex.Unlock()
+			return err
+		}
+		buckets = cache
+	}
+	bucketMutex.Unlock()
+
+	ruleMutex.Lock()
+	switch {
+	case limiter == nil:
+		limiter = rate.NewLimiter(rate.Limit(conf.TokenPerSecond), conf.TokenMax)
+	case limiter.Burst() == conf.TokenMax:
+		limiter.SetLimit(rate.Limit(conf.TokenPerSecond))
+	default:
+		// the burst can not be changed in place, the new limiter starts empty
+		// so a reload never hands out a fresh burst
+		limiter = rate.NewLimiter(rate.Limit(conf.TokenPerSecond), conf.TokenMax)
+		limiter.AllowN(time.Now(), conf.TokenMax)
+	}
+	limitRules = rules
+	ruleMutex.Unlock()
+	return nil
+}
+
+// limitAppKey is only used with auth on, when the type is bound to the api key.
+func limitAppKey(r *http.Request) string {
+	return r.FormValue("type")
+}
+
+func limitUserKey(r *http.Request) string {
+	id := r.FormValue("id")
+	if id == "" {
+		id = r.FormValue("uid")
+	}
+	if id == "" {
+		id = r.FormValue("loserId")
+	}
+	if id == "" {
+		return ""
+	}
+	return r.FormValue("type") + ":" + id
+}
+
+func checkLimit(w http.ResponseWriter, r *http.Request) bool {
+	if isDraining() {
+		http.Error(w, http.StatusText(503), http.StatusServiceUnavailable)
+		return false
+	}
+	ruleMutex.RLock()
+	global, rules := limiter, limitRules
+	ruleMutex.RUnlock()
+
+	if global.Allow() == false {
+		http.Error(w, http.StatusText(429), http.StatusTooManyRequests)
+		return false
+	}
+	if len(rules) == 0 {
+		return true
+	}
+
+	// the keys live in the form, a broken form is rejected by the handler later
+	r.ParseForm()
+
+	limit, remaining := 0, math.MaxInt32
+	for _, rule := range rules {
+		key := rule.key(r)
+		if key == "" {
+			continue
+		}
+		allowed, left, retry := takeToken(rule, key)
+		if left < remaining {
+			limit, remaining = rule.burst, left
+		}
+		if !allowed {
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(rule.burst))
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+			http.Error(w, http.StatusText(429), http.StatusTooManyRequests)
+			return false
+		}
+	}
+	if limit > 0 {
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	}
+	return true
+}
+
+func takeToken(rule *limitRule, key string) (bool, int, time.Duration) {
+	key = define.RateLimitPrefix + rule.name + ":" + key
+
+	// the redis clock refills shared buckets, instance clocks may differ
+	if config.GetConfig().RateLimitMode == define.QueueRedis {
+		allowed, remaining, retryMs, err := dbInstance.TakeToken(key, rule.rate, rule.burst)
+		if err != nil {
+			// do not block traffic because redis is unreachable
+			log.Error(fmt.Sprintf("TakeToken key:%v error:%v", key, err))
+			return true, rule.burst, 0
+		}
+		return allowed, remaining, time.Duration(retryMs) * time.Millisecond
+	}
+
+	now := time.Now()
+	b := localBucket(key, rule.burst, now)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.tokens = math.Min(float64(rule.burst), b.tokens+now.Sub(b.last).Seconds()*rule.rate)
+	b.last = now
+	if b.tokens < 1 {
+		retry := time.Duration((1 - b.tokens) / rule.rate * float64(time.Second))
+		return false, 0, retry
+	}
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+func localBucket(key string, burst int, now time.Time) *bucket {
+	bucketMutex.Lock()
+	defer bucketMutex.Unlock()
+
+	if v, ok := buckets.Get(key); ok {
+		return v.(*bucket)
+	}
+	b := &bucket{tokens: float64(burst), last: now}
+	buckets.Add(key, b)
+	return b
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"fmt"
+	"github.com/coschain/contentos-go/prototype"
+	"math/rand"
+	"proxy/config"
+	"proxy/define"
+	"proxy/utils"
+	"strings"
+)
+
+const nameAttempts = 5
+
+/**
+ * 账号名登记
+ *   名字由昵称生成，需符合链上规则（6-16 位字母数字），并在 redis 中原子地登记到账号 id 上，
+ *   冲突时换一个随机后缀重试。链上是否已被占用由 worker 在创建账号时检查。
+ */
+func claimName(id string, t int64, nickname string) (string, error) {
+	for i := 0; i < nameAttempts; i++ {
+		name := generateName(t, nickname)
+		if err := (&prototype.AccountName{Value: name}).Validate(); err != nil {
+			log.Error(fmt.Sprintf("generated name:%v invalid:%v", name, err))
+			continue
+		}
+		claimed, err := dbInstance.SETNX(define.NameRegistryPrefix+name, id, 0)
+		if err != nil {
+			return "", err
+		}
+		if claimed {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no free name for:%v after %v attempts", nickname, nameAttempts)
+}
+
+// accountName returns the recorded name of id, or claims a new one for an
+// account that does not exist yet.
+func accountName(id string, t int64, nickname string) (string, error) {
+	name, err := getAccountName(id)
+	if err != nil || name != "" {
+		return name, err
+	}
+	return claimName(id, t, nickname)
+}
+
+/**
+ * 由昵称生成账号名，按 app 的 NamePolicy：
+ *   translit  音译为小写字母数字（中文转拼音）
+ *   strip     只保留字母数字
+ *   random    完全随机
+ *   前缀为小写的 app 名，后接 8 位随机串，整个名字只有小写字母和数字
+ */
+func generateName(t int64, nickname string) string {
+	newName := ""
+	if app := config.GetConfig().AppMap[t]; app != nil {
+		prefix := getSpecificPrefix("name", t)
+		switch app.NamePolicy {
+		case define.NameTranslit:
+			newName = prefix + utils.Transliterate(nickname)
+		case define.NameStrip:
+			newName = makeValidateName(prefix + nickname)
+		}
+	}
+	if len(newName) != 0 { // got a valid subname
+		return utils.GenerateName(newName)
+	}
+	// all char invalid
+	return utils.RandStringBytes(rand.Intn(8) + 8) // 8 ~ 15
+}
+
+func makeValidateName(name string) string {
+
+	buf := []byte(name)
+	var newName strings.Builder
+	for i, val := range buf {
+		if !isValidNameChar(val) {
+			continue
+		} else {
+			newName.WriteString(string(name[i]))
+		}
+	}
+	return newName.String()
+}
+
+func isValidNameChar(c byte) bool {
+	if c >= '0' && c <= '9' {
+		return true
+	} else if c >= 'a' && c <= 'z' {
+		return true
+	} else if c >= 'A' && c <= 'Z' {
+		return true
+	} else {
+		return false
+	}
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/coschain/contentos-go/prototype"
+	"math/big"
+)
+
+const (
+	hardened = 0x80000000
+	purpose  = 44
+	coinType = 3077
+)
+
+// curveN is the order of secp256k1, the curve of contentos keys
+var curveN, _ = new(big.Int).SetString("FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEBAAEDCE6AF48A03BBFD25E8CD0364141", 16)
+
+var errInvalidChild = errors.New("derived key invalid")
+
+// KeyPath is the BIP32 path of a user key, every level hardened:
+// m/44'/3077'/type'/uid_mid'/uid_low', the low 62 bits of the user id split
+// into 31 bit halves. Ids with any of the top 2 bits set get one more level
+// uid_top', so the paths of smaller ids stay as they were.
+func KeyPath(appType int64, userId uint64) string {
+	path := fmt.Sprintf("m/%v'/%v'/%v'", purpose, coinType, appType)
+	for _, index := range userIndexes(userId) {
+		path += fmt.Sprintf("/%v'", index)
+	}
+	return path
+}
+
+func userIndexes(userId uint64) []uint32 {
+	indexes := []uint32{uint32(userId >> 31 & (hardened - 1)), uint32(userId & (hardened - 1))}
+	if top := userId >> 62; top != 0 {
+		indexes = append(indexes, uint32(top))
+	}
+	return indexes
+}
+
+/**
+ * 从主种子派生用户密钥
+ *   同一个种子、app 类型和用户 id 总是得到同一个密钥，redis 丢失时可以由种子重新生成
+ *	return:
+ *		string  公钥
+ *		string  私钥
+ */
+func DeriveKey(seed []byte, appType int64, userId uint64) (string, string, error) {
+	if appType < 0 || appType >= hardened {
+		return "", "", fmt.Errorf("app type %v out of range", appType)
+	}
+	key, chain, err := masterKey(seed)
+	if err != nil {
+		return "", "", err
+	}
+	for _, index := range append([]uint32{purpose, coinType, uint32(appType)}, userIndexes(userId)...) {
+		if key, chain, err = childKey(key, chain, index|hardened); err != nil {
+			return "", "", err
+		}
+	}
+
+	privKey := prototype.PrivateKeyFromBytes(key)
+	pubKey, err := privKey.PubKey()
+	if err != nil {
+		return "", "", err
+	}
+	return pubKey.ToWIF(), privKey.ToWIF(), nil
+}
+
+func masterKey(seed []byte) ([]byte, []byte, error) {
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	if !validKey(sum[:32]) {
+		return nil, nil, errInvalidChild
+	}
+	return sum[:32], sum[32:], nil
+}
+
+// childKey derives a hardened child, a key out of range is an error
+// instead of skipping to the next index so paths stay fixed.
+func childKey(key, chain []byte, index uint32) ([]byte, []byte, error) {
+	data := make([]byte, 0, 37)
+	data = append(data, 0)
+	data = append(data, key...)
+	data = append(data, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], index)
+
+	mac := hmac.New(sha512.New, chain)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+	if !validKey(sum[:32]) {
+		return nil, nil, errInvalidChild
+	}
+	k := new(big.Int).SetBytes(sum[:32])
+	k.Add(k, new(big.Int).SetBytes(key))
+	k.Mod(k, curveN)
+	if k.Sign() == 0 {
+		return nil, nil, errInvalidChild
+	}
+	child := make([]byte, 32)
+	b := k.Bytes()
+	copy(child[32-len(b):], b)
+	return child, sum[32:], nil
+}
+
+func validKey(b []byte) bool {
+	k := new(big.Int).SetBytes(b)
+	return k.Sign() > 0 && k.Cmp(curveN) < 0
+}
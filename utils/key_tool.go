@@ -4,6 +4,7 @@ import (
 	"github.com/coschain/contentos-go/prototype"
 	"hash/crc32"
 	"math/rand"
+	"strings"
 	"time"
 )
 
@@ -11,7 +12,7 @@ const (
 	leftPos = 0
 	rightPos = 8
 	randomLen = 8
- 	letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+ 	letterBytes = "abcdefghijklmnopqrstuvwxyz0123456789"
 )
 
 func init() {
@@ -45,7 +46,8 @@ func GenerateName(name string) string {
 	}
 	randomStr := RandStringBytes(randomLen)
 
-	newName += randomStr
+	// the whole name is lower cased, the app prefix included
+	newName = strings.ToLower(newName) + randomStr
 	return newName
 }
 
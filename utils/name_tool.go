@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"github.com/mozillazg/go-pinyin"
+	"golang.org/x/text/unicode/norm"
+	"strings"
+	"unicode"
+)
+
+var pinyinArgs = pinyin.NewArgs()
+
+// letters of scripts without decomposition to latin
+var translitTable = map[rune]string{
+	// cyrillic
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh", 'з': "z", 'и': "i", 'й': "y",
+	'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f",
+	'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ы': "y", 'э': "e", 'ю': "yu", 'я': "ya",
+	'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g",
+	// greek
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i", 'θ': "th", 'ι': "i", 'κ': "k", 'λ': "l",
+	'μ': "m", 'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y", 'φ': "f",
+	'χ': "ch", 'ψ': "ps", 'ω': "o",
+	// latin letters that do not decompose
+	'ß': "ss", 'æ': "ae", 'ø': "o", 'œ': "oe", 'đ': "d", 'ł': "l", 'þ': "th", 'ð': "d",
+}
+
+// Transliterate turns a nickname into lower case ascii letters and digits:
+// han characters become pinyin, accents are removed, cyrillic and greek are
+// romanized and everything else is dropped.
+func Transliterate(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(s) {
+		r = unicode.ToLower(r)
+		switch {
+		case r < unicode.MaxASCII:
+			if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+				b.WriteRune(r)
+			}
+		case unicode.Is(unicode.Han, r):
+			if p := pinyin.SinglePinyin(r, pinyinArgs); len(p) > 0 {
+				b.WriteString(p[0])
+			}
+		default:
+			// combining marks left by the decomposition are dropped here
+			b.WriteString(translitTable[r])
+		}
+	}
+	return b.String()
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"encoding/binary"
+	"errors"
 	"github.com/coschain/contentos-go/prototype"
 	"github.com/coschain/contentos-go/rpc/pb"
 	"proxy/rpc"
@@ -21,6 +22,9 @@ func GenerateSignedTx(privateKey string,client *rpc.Client, ops ...interface{})
 		client.SetAlive(false)
 		return nil, err
 	}
+	if resp.GetState().GetDgpo().GetHeadBlockId() == nil || resp.State.Dgpo.GetTime() == nil {
+		return nil, errors.New("rpc GetStatisticsInfo returned no head block")
+	}
 	refBlockPrefix := binary.BigEndian.Uint32(resp.State.Dgpo.HeadBlockId.Hash[8:12])
 	// occupant implement
 	refBlockNum := uint32(resp.State.Dgpo.HeadBlockNumber & 0x7ff)